@@ -1,3 +1,22 @@
 fn main() {
   uniffi::generate_scaffolding("src/glalby.udl").unwrap();
+
+  println!("cargo:rerun-if-changed=Cargo.lock");
+  println!(
+    "cargo:rustc-env=GL_CLIENT_VERSION={}",
+    locked_version("gl-client").unwrap_or_else(|| "unknown".to_string())
+  );
+}
+
+// Reads the resolved version of a dependency from Cargo.lock, since cargo
+// only exposes the version of the crate being built.
+fn locked_version(name: &str) -> Option<String> {
+  let lock = std::fs::read_to_string("Cargo.lock").ok()?;
+  let mut lines = lock.lines();
+  lines.find(|line| *line == format!("name = \"{}\"", name))?;
+  lines
+    .next()?
+    .strip_prefix("version = \"")?
+    .strip_suffix('"')
+    .map(String::from)
 }
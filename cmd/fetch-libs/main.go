@@ -0,0 +1,216 @@
+// Command fetch-libs downloads the prebuilt glalby libraries of a release,
+// verifies them against the release's SHA256SUMS and unpacks each target
+// into its own directory, the layout glalby-go's cgo flags expect.
+//
+// It is meant to be run from go:generate next to the generated bindings:
+//
+//	//go:generate go run github.com/getAlby/glalby/cmd/fetch-libs
+//
+// Without -version it fetches the release matching the required version of
+// this module, or the git tag of the current checkout.
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+const (
+	modulePath    = "github.com/getAlby/glalby"
+	archivePrefix = "glalby-bindings-"
+
+	// Bounds each download, including reading the body, so go generate
+	// fails instead of hanging on a stalled connection.
+	downloadTimeout = 5 * time.Minute
+)
+
+var httpClient = &http.Client{Timeout: downloadTimeout}
+
+func main() {
+	version := flag.String("version", "", "release tag to fetch, e.g. v0.1.0; defaults to the module version or git tag")
+	repo := flag.String("repo", "getAlby/glalby", "GitHub repository the release belongs to")
+	out := flag.String("out", ".", "directory to unpack the target directories into")
+	targets := flag.String("targets", "", "comma-separated targets to fetch, all targets by default")
+	flag.Parse()
+
+	if *version == "" {
+		*version = defaultVersion()
+	}
+	if *version == "" {
+		log.Fatal("could not determine the release version, pass -version")
+	}
+
+	baseURL := fmt.Sprintf("https://github.com/%s/releases/download/%s/", *repo, *version)
+	if err := fetch(baseURL, *out, splitTargets(*targets)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func fetch(baseURL, out string, targets map[string]bool) error {
+	sums, err := download(baseURL + "SHA256SUMS")
+	if err != nil {
+		return err
+	}
+	checksums, err := parseChecksums(sums)
+	if err != nil {
+		return err
+	}
+
+	found := 0
+	for archive, checksum := range checksums {
+		target := targetName(archive)
+		if len(targets) > 0 && !targets[target] {
+			continue
+		}
+		found++
+
+		data, err := download(baseURL + archive)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != checksum {
+			return fmt.Errorf("checksum mismatch for %s", archive)
+		}
+		if err := unpack(data, filepath.Join(out, target)); err != nil {
+			return fmt.Errorf("failed to unpack %s: %w", archive, err)
+		}
+		log.Printf("fetched %s", target)
+	}
+
+	if found < len(targets) {
+		return errors.New("some of the requested targets are not part of the release")
+	}
+	return nil
+}
+
+func download(url string) ([]byte, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseChecksums reads sha256sum output, mapping archive names to their
+// hex encoded checksums.
+func parseChecksums(data []byte) (map[string]string, error) {
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid checksum line: %q", scanner.Text())
+		}
+		name := strings.TrimPrefix(fields[1], "*")
+		if !strings.HasPrefix(name, archivePrefix) || !strings.HasSuffix(name, ".tar.gz") {
+			continue
+		}
+		// Archive names become directory names, so they must not be able to
+		// point outside the output directory.
+		if strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") || targetName(name) == "" {
+			return nil, fmt.Errorf("invalid archive name: %q", name)
+		}
+		if _, err := hex.DecodeString(fields[0]); err != nil || len(fields[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("invalid checksum for %s: %q", name, fields[0])
+		}
+		checksums[name] = strings.ToLower(fields[0])
+	}
+	return checksums, scanner.Err()
+}
+
+func targetName(archive string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(archive, archivePrefix), ".tar.gz")
+}
+
+// defaultVersion returns the version of this module the build depends on,
+// which is what go:generate in glalby-go resolves, falling back to the tag
+// of the current git checkout.
+func defaultVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		modules := append([]*debug.Module{&info.Main}, info.Deps...)
+		for _, module := range modules {
+			if module.Path == modulePath && module.Version != "" && module.Version != "(devel)" {
+				return module.Version
+			}
+		}
+	}
+
+	tag, err := exec.Command("git", "describe", "--tags", "--exact-match").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(tag))
+}
+
+// unpack extracts the regular files of a gzipped tarball into dir. Archives
+// are flat, so directories in entry names are dropped.
+func unpack(data []byte, dir string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		file, err := os.OpenFile(filepath.Join(dir, filepath.Base(header.Name)), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, tr)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func splitTargets(targets string) map[string]bool {
+	set := make(map[string]bool)
+	for _, target := range strings.Split(targets, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			set[target] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func archive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseChecksums(t *testing.T) {
+	sum := strings.Repeat("ab", sha256.Size)
+
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "valid",
+			input: sum + "  glalby-bindings-x86_64-unknown-linux-gnu.tar.gz\n\n",
+			want:  map[string]string{"glalby-bindings-x86_64-unknown-linux-gnu.tar.gz": sum},
+		},
+		{
+			name:  "binary mode marker",
+			input: sum + " *glalby-bindings-universal-macos.tar.gz\n",
+			want:  map[string]string{"glalby-bindings-universal-macos.tar.gz": sum},
+		},
+		{
+			name:  "unrelated files are skipped",
+			input: sum + "  notes.txt\n",
+			want:  map[string]string{},
+		},
+		{
+			name:    "bad checksum line",
+			input:   sum + "  glalby-bindings-a.tar.gz extra\n",
+			wantErr: true,
+		},
+		{
+			name:    "checksum is not hex",
+			input:   strings.Repeat("zz", sha256.Size) + "  glalby-bindings-a.tar.gz\n",
+			wantErr: true,
+		},
+		{
+			name:    "checksum has the wrong length",
+			input:   "abcd  glalby-bindings-a.tar.gz\n",
+			wantErr: true,
+		},
+		{
+			name:    "path traversal",
+			input:   sum + "  glalby-bindings-../../etc.tar.gz\n",
+			wantErr: true,
+		},
+		{
+			name:    "path separator",
+			input:   sum + "  glalby-bindings-a/b.tar.gz\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty target",
+			input:   sum + "  glalby-bindings-.tar.gz\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseChecksums([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitTargets(t *testing.T) {
+	tests := []struct {
+		input string
+		want  map[string]bool
+	}{
+		{"", map[string]bool{}},
+		{"a", map[string]bool{"a": true}},
+		{" a , b,,", map[string]bool{"a": true, "b": true}},
+	}
+
+	for _, tt := range tests {
+		if got := splitTargets(tt.input); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitTargets(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	dir := t.TempDir()
+	data := archive(t, map[string]string{
+		"./libglalby_bindings.so": "shared",
+		"../../escape.a":          "static",
+	})
+
+	if err := unpack(data, dir); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"libglalby_bindings.so": "shared", "escape.a": "static"} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	if err := unpack([]byte("not gzip"), dir); err == nil {
+		t.Error("expected an error for a corrupt archive")
+	}
+}
+
+func TestFetch(t *testing.T) {
+	good := archive(t, map[string]string{"libglalby_bindings.so": "shared"})
+
+	tests := []struct {
+		name    string
+		sums    string
+		files   map[string][]byte
+		targets string
+		wantErr bool
+	}{
+		{
+			name:  "valid",
+			sums:  digest(good) + "  glalby-bindings-x86_64-unknown-linux-gnu.tar.gz\n",
+			files: map[string][]byte{"glalby-bindings-x86_64-unknown-linux-gnu.tar.gz": good},
+		},
+		{
+			name:    "missing archive",
+			sums:    digest(good) + "  glalby-bindings-x86_64-unknown-linux-gnu.tar.gz\n",
+			files:   map[string][]byte{},
+			wantErr: true,
+		},
+		{
+			name:    "mismatched digest",
+			sums:    digest([]byte("other")) + "  glalby-bindings-x86_64-unknown-linux-gnu.tar.gz\n",
+			files:   map[string][]byte{"glalby-bindings-x86_64-unknown-linux-gnu.tar.gz": good},
+			wantErr: true,
+		},
+		{
+			name:    "requested target not in release",
+			sums:    digest(good) + "  glalby-bindings-x86_64-unknown-linux-gnu.tar.gz\n",
+			files:   map[string][]byte{"glalby-bindings-x86_64-unknown-linux-gnu.tar.gz": good},
+			targets: "riscv64gc-unknown-linux-gnu",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				name := strings.TrimPrefix(r.URL.Path, "/")
+				if name == "SHA256SUMS" {
+					w.Write([]byte(tt.sums))
+					return
+				}
+				data, ok := tt.files[name]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write(data)
+			}))
+			defer server.Close()
+
+			out := t.TempDir()
+			err := fetch(server.URL+"/", out, splitTargets(tt.targets))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				if _, err := os.Stat(filepath.Join(out, "x86_64-unknown-linux-gnu", "libglalby_bindings.so")); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
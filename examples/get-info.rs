@@ -1,11 +1,12 @@
-use glalby_bindings::{new_blocking_greenlight_alby_client, recover};
+use glalby_bindings::{new_blocking_greenlight_alby_client, recover, Network};
 
 fn main() {
     let mnemonic = std::env::var("MNEMONIC").unwrap();
 
-    let credentials = recover(mnemonic.clone()).unwrap();
+    let credentials = recover(mnemonic.clone(), Network::Bitcoin, None).unwrap();
 
-    let client = new_blocking_greenlight_alby_client(mnemonic, credentials).unwrap();
+    let client =
+        new_blocking_greenlight_alby_client(mnemonic, credentials, Network::Bitcoin, None).unwrap();
     let result = client.get_info().unwrap();
 
     println!("Result: {:?}", result);
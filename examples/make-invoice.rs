@@ -1,14 +1,17 @@
-use glalby_bindings::{new_blocking_greenlight_alby_client, recover, MakeInvoiceRequest};
+use glalby_bindings::{
+    new_blocking_greenlight_alby_client, recover, MakeInvoiceRequest, Msat, Network,
+};
 
 fn main() {
     let mnemonic = std::env::var("MNEMONIC").unwrap();
 
-    let credentials = recover(mnemonic.clone()).unwrap();
+    let credentials = recover(mnemonic.clone(), Network::Bitcoin, None).unwrap();
 
-    let client = new_blocking_greenlight_alby_client(mnemonic, credentials).unwrap();
+    let client =
+        new_blocking_greenlight_alby_client(mnemonic, credentials, Network::Bitcoin, None).unwrap();
     let result = client
         .make_invoice(MakeInvoiceRequest {
-            amount_msat: 1000,
+            amount_msat: Msat(1000),
             description: String::from("Test description"),
             label: rand::random::<u64>().to_string(),
             cltv: None,
@@ -0,0 +1,88 @@
+use std::sync::Arc;
+use std::thread;
+use std::time::{Duration, Instant};
+
+use glalby_bindings::{
+    new_blocking_greenlight_alby_client, recover, BlockingGreenlightAlbyClient,
+    ListInvoicesRequest, ListPaymentsRequest, Network,
+};
+
+// Compares running read calls one after another with running them from
+// several threads at once on the same client.
+fn main() {
+    let mnemonic = std::env::var("MNEMONIC").unwrap();
+    let threads: usize = std::env::var("THREADS")
+        .map(|t| t.parse().unwrap())
+        .unwrap_or(8);
+    let calls_per_thread: usize = std::env::var("CALLS")
+        .map(|c| c.parse().unwrap())
+        .unwrap_or(5);
+
+    let credentials = recover(mnemonic.clone(), Network::Bitcoin, None).unwrap();
+
+    let client =
+        new_blocking_greenlight_alby_client(mnemonic, credentials, Network::Bitcoin, None).unwrap();
+    // Make sure the node is scheduled before timing anything.
+    client.get_info().unwrap();
+
+    let start = Instant::now();
+    for _ in 0..threads * calls_per_thread {
+        run_calls(&client);
+    }
+    report("Sequential", threads * calls_per_thread, start.elapsed());
+
+    let start = Instant::now();
+    let handles: Vec<_> = (0..threads)
+        .map(|_| {
+            let client = client.clone();
+            thread::spawn(move || {
+                for _ in 0..calls_per_thread {
+                    run_calls(&client);
+                }
+            })
+        })
+        .collect();
+    for handle in handles {
+        handle.join().unwrap();
+    }
+    report("Parallel", threads * calls_per_thread, start.elapsed());
+}
+
+fn run_calls(client: &Arc<BlockingGreenlightAlbyClient>) {
+    client
+        .list_invoices(ListInvoicesRequest {
+            label: None,
+            invstring: None,
+            payment_hash: None,
+            offer_id: None,
+            index: None,
+            start: None,
+            limit: Some(10),
+            status: None,
+            exclude_expired: None,
+        })
+        .unwrap();
+    client
+        .list_payments(ListPaymentsRequest {
+            bolt11: None,
+            payment_hash: None,
+            status: None,
+            index: None,
+            start: None,
+            limit: Some(10),
+            created_from: None,
+            created_to: None,
+            sort_direction: None,
+        })
+        .unwrap();
+}
+
+fn report(name: &str, rounds: usize, elapsed: Duration) {
+    println!(
+        "{}: {} rounds in {:?} ({:.1} calls/s)",
+        name,
+        rounds,
+        elapsed,
+        (rounds * 2) as f64 / elapsed.as_secs_f64()
+    );
+}
@@ -10,14 +10,18 @@ fi
 
 uniffi-bindgen-go src/glalby.udl -o . -c ./uniffi.toml
 
+# Set PROFILE=release-small and CARGO_FLAGS=--no-default-features for a
+# smaller library.
+PROFILE=${PROFILE:-release}
+
 build_lib() {
   local TOOL=$1
   local TARGET=$2
   local OUTPUT_FILE=$3
 
-  $TOOL build --release --target $TARGET || exit 1
+  $TOOL build --profile $PROFILE $CARGO_FLAGS --target $TARGET || exit 1
   mkdir -p "glalby/$TARGET" || exit 1
-  cp "target/$TARGET/release/$OUTPUT_FILE" "glalby/$TARGET/" || exit 1
+  cp "target/$TARGET/$PROFILE/$OUTPUT_FILE" "glalby/$TARGET/" || exit 1
 }
 
 # If we're running on macOS, build the macOS library using the host compiler.
@@ -27,8 +31,15 @@ if [[ "$OSTYPE" == "darwin"* ]]; then
   build_lib "cargo" "x86_64-apple-darwin" "libglalby_bindings.dylib"
   mkdir -p glalby/universal-macos || exit 1
   lipo -create -output "glalby/universal-macos/libglalby_bindings.dylib" "glalby/aarch64-apple-darwin/libglalby_bindings.dylib" "glalby/x86_64-apple-darwin/libglalby_bindings.dylib" || exit 1
+  build_lib "cargo" "aarch64-apple-ios" "libglalby_bindings.a"
+  build_lib "cargo" "aarch64-apple-ios-sim" "libglalby_bindings.a"
 fi
 
 build_lib "cross" "x86_64-unknown-linux-gnu" "libglalby_bindings.so"
+build_lib "cross" "x86_64-unknown-linux-musl" "libglalby_bindings.so"
+build_lib "cross" "aarch64-unknown-linux-musl" "libglalby_bindings.so"
+build_lib "cross" "aarch64-linux-android" "libglalby_bindings.so"
+build_lib "cross" "x86_64-linux-android" "libglalby_bindings.so"
 build_lib "cross" "x86_64-pc-windows-gnu" "glalby_bindings.dll"
 build_lib "cross" "arm-unknown-linux-gnueabihf" "libglalby_bindings.so"
+build_lib "cross" "riscv64gc-unknown-linux-gnu" "libglalby_bindings.so"
@@ -0,0 +1,85 @@
+use std::fmt;
+
+use gl_client::pb::cln;
+
+use crate::UniffiCustomTypeConverter;
+
+const MSAT_PER_SAT: u64 = 1_000;
+const MSAT_PER_BTC: u64 = 100_000_000_000;
+
+// An amount in millisatoshis. Crosses the FFI boundary as a plain u64, so
+// the bindings see a named alias rather than an anonymous integer, and get
+// the conversions below as the msat_* namespace functions.
+#[derive(Copy, Clone, Debug, Default, PartialEq, Eq, PartialOrd, Ord, Hash)]
+pub struct Msat(pub u64);
+
+impl Msat {
+    // Saturates at u64::MAX msat, far above the 21M BTC that can exist.
+    pub fn from_sat(sat: u64) -> Self {
+        Msat(sat.saturating_mul(MSAT_PER_SAT))
+    }
+
+    // Rounds down to whole satoshis.
+    pub fn sat(&self) -> u64 {
+        self.0 / MSAT_PER_SAT
+    }
+
+    pub fn btc(&self) -> f64 {
+        self.0 as f64 / MSAT_PER_BTC as f64
+    }
+}
+
+impl fmt::Display for Msat {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{} msat", self.0)
+    }
+}
+
+impl From<cln::Amount> for Msat {
+    fn from(amount: cln::Amount) -> Self {
+        Msat(amount.msat)
+    }
+}
+
+impl From<Msat> for cln::Amount {
+    fn from(amount: Msat) -> Self {
+        cln::Amount { msat: amount.0 }
+    }
+}
+
+impl UniffiCustomTypeConverter for Msat {
+    type Builtin = u64;
+
+    fn into_custom(val: Self::Builtin) -> uniffi::Result<Self> {
+        Ok(Msat(val))
+    }
+
+    fn from_custom(obj: Self) -> Self::Builtin {
+        obj.0
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn converts_sat() {
+        assert_eq!(Msat::from_sat(21), Msat(21_000));
+        assert_eq!(Msat::from_sat(u64::MAX), Msat(u64::MAX));
+        assert_eq!(Msat(21_999).sat(), 21);
+        assert_eq!(Msat(999).sat(), 0);
+    }
+
+    #[test]
+    fn converts_btc() {
+        assert_eq!(Msat(150_000_000_000).btc(), 1.5);
+        assert_eq!(Msat::from_sat(1).btc(), 0.000_000_01);
+        assert_eq!(Msat(0).btc(), 0.0);
+    }
+
+    #[test]
+    fn formats_msat() {
+        assert_eq!(Msat(1_500).to_string(), "1500 msat");
+    }
+}
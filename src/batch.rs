@@ -0,0 +1,82 @@
+use crate::greenlight_alby_client::{
+    GetInfoResponse, GreenlightAlbyClient, ListConfigsRequest, ListConfigsResponse,
+    ListFundsRequest, ListFundsResponse, ListInvoicesRequest, ListInvoicesResponse,
+    ListPaymentsRequest, ListPaymentsResponse, Result, SdkError,
+};
+
+#[derive(Clone, Debug)]
+pub enum BatchRequest {
+    GetInfo,
+    ListFunds { request: ListFundsRequest },
+    ListInvoices { request: ListInvoicesRequest },
+    ListPayments { request: ListPaymentsRequest },
+    ListConfigs { request: ListConfigsRequest },
+}
+
+#[derive(Clone, Debug)]
+pub enum BatchResponse {
+    GetInfo { response: GetInfoResponse },
+    ListFunds { response: ListFundsResponse },
+    ListInvoices { response: ListInvoicesResponse },
+    ListPayments { response: ListPaymentsResponse },
+    ListConfigs { response: ListConfigsResponse },
+    // Requests fail independently, so one failure doesn't lose the other
+    // responses.
+    Error { error: SdkError },
+}
+
+impl<T> From<Result<T>> for BatchResponse
+where
+    T: Into<BatchResponse>,
+{
+    fn from(result: Result<T>) -> Self {
+        match result {
+            Ok(response) => response.into(),
+            Err(error) => BatchResponse::Error { error },
+        }
+    }
+}
+
+impl From<GetInfoResponse> for BatchResponse {
+    fn from(response: GetInfoResponse) -> Self {
+        BatchResponse::GetInfo { response }
+    }
+}
+
+impl From<ListFundsResponse> for BatchResponse {
+    fn from(response: ListFundsResponse) -> Self {
+        BatchResponse::ListFunds { response }
+    }
+}
+
+impl From<ListInvoicesResponse> for BatchResponse {
+    fn from(response: ListInvoicesResponse) -> Self {
+        BatchResponse::ListInvoices { response }
+    }
+}
+
+impl From<ListPaymentsResponse> for BatchResponse {
+    fn from(response: ListPaymentsResponse) -> Self {
+        BatchResponse::ListPayments { response }
+    }
+}
+
+impl From<ListConfigsResponse> for BatchResponse {
+    fn from(response: ListConfigsResponse) -> Self {
+        BatchResponse::ListConfigs { response }
+    }
+}
+
+impl GreenlightAlbyClient {
+    // Runs a single request of a batch. The batch itself is run by the
+    // blocking client, which retries each request on its own.
+    pub(crate) async fn batch_request(&self, request: BatchRequest) -> Result<BatchResponse> {
+        Ok(match request {
+            BatchRequest::GetInfo => self.get_info().await?.into(),
+            BatchRequest::ListFunds { request } => self.list_funds(request).await?.into(),
+            BatchRequest::ListInvoices { request } => self.list_invoices(request).await?.into(),
+            BatchRequest::ListPayments { request } => self.list_payments(request).await?.into(),
+            BatchRequest::ListConfigs { request } => self.list_configs(request).await?.into(),
+        })
+    }
+}
@@ -0,0 +1,135 @@
+use std::future::Future;
+use std::sync::atomic::{AtomicBool, AtomicUsize, Ordering};
+use std::time::Duration;
+
+use anyhow::anyhow;
+use tokio::sync::{watch, Notify};
+
+use crate::greenlight_alby_client::{Result, SdkError};
+
+// Keeps track of calls in progress so the client can be closed once they
+// have finished, or cancel them if they take too long.
+pub(crate) struct CallTracker {
+    in_flight: AtomicUsize,
+    closing: AtomicBool,
+    idle: Notify,
+    cancel: watch::Sender<bool>,
+}
+
+// Lets a caller abandon calls made through a client created with
+// with_cancel_token, e.g. when the user gives up on a slow payment.
+pub struct CancelToken {
+    cancelled: watch::Sender<bool>,
+}
+
+impl CancelToken {
+    pub fn new() -> Self {
+        CancelToken {
+            cancelled: watch::channel(false).0,
+        }
+    }
+
+    pub fn cancel(&self) {
+        self.cancelled.send_replace(true);
+    }
+
+    pub fn is_cancelled(&self) -> bool {
+        *self.cancelled.borrow()
+    }
+
+    async fn cancelled(&self) {
+        let mut cancelled = self.cancelled.subscribe();
+        while !*cancelled.borrow_and_update() {
+            let _ = cancelled.changed().await;
+        }
+    }
+}
+
+struct InFlightGuard<'a>(&'a CallTracker);
+
+impl Drop for InFlightGuard<'_> {
+    fn drop(&mut self) {
+        if self.0.in_flight.fetch_sub(1, Ordering::SeqCst) == 1 {
+            self.0.idle.notify_waiters();
+        }
+    }
+}
+
+impl CallTracker {
+    pub(crate) fn new() -> Self {
+        CallTracker {
+            in_flight: AtomicUsize::new(0),
+            closing: AtomicBool::new(false),
+            idle: Notify::new(),
+            cancel: watch::channel(false).0,
+        }
+    }
+
+    pub(crate) async fn run<T>(
+        &self,
+        call: impl Future<Output = Result<T>>,
+        timeout: Option<Duration>,
+        cancel_token: Option<&CancelToken>,
+    ) -> Result<T> {
+        self.in_flight.fetch_add(1, Ordering::SeqCst);
+        let _guard = InFlightGuard(self);
+        if self.closing.load(Ordering::SeqCst) {
+            return Err(SdkError::greenlight_api(anyhow!("client is closed")));
+        }
+
+        let call =
+            async {
+                match timeout {
+                    Some(timeout) => tokio::time::timeout(timeout, call).await.map_err(|_| {
+                        SdkError::Timeout {
+                            message: format!("call did not finish within {:?}", timeout),
+                        }
+                    })?,
+                    None => call.await,
+                }
+            };
+
+        let cancelled = async {
+            match cancel_token {
+                Some(cancel_token) => cancel_token.cancelled().await,
+                None => std::future::pending().await,
+            }
+        };
+
+        let mut cancel = self.cancel.subscribe();
+        tokio::select! {
+            result = call => result,
+            _ = cancel.changed() => Err(SdkError::greenlight_api(anyhow!(
+                "client was closed during the call"
+            ))),
+            _ = cancelled => Err(SdkError::Cancelled {
+                message: "call was cancelled".to_string(),
+            }),
+        }
+    }
+
+    // Rejects new calls and waits up to timeout for the ones in progress,
+    // cancelling any that are left.
+    pub(crate) async fn close(&self, timeout: Duration) {
+        self.closing.store(true, Ordering::SeqCst);
+        if tokio::time::timeout(timeout, self.wait_idle())
+            .await
+            .is_err()
+        {
+            self.cancel.send_replace(true);
+            self.wait_idle().await;
+        }
+    }
+
+    async fn wait_idle(&self) {
+        loop {
+            let idle = self.idle.notified();
+            tokio::pin!(idle);
+            idle.as_mut().enable();
+            if self.in_flight.load(Ordering::SeqCst) == 0 {
+                return;
+            }
+            idle.await;
+        }
+    }
+}
@@ -0,0 +1,84 @@
+use std::num::NonZeroU32;
+
+use anyhow::{anyhow, Context};
+use ring::aead::{Aad, LessSafeKey, Nonce, UnboundKey, CHACHA20_POLY1305, NONCE_LEN};
+use ring::pbkdf2;
+use ring::rand::{SecureRandom, SystemRandom};
+use zeroize::Zeroizing;
+
+use crate::greenlight_alby_client::{GreenlightCredentials, Result, SdkError};
+
+const SALT_LEN: usize = 16;
+const PBKDF2_ITERATIONS: u32 = 100_000;
+
+fn derive_key(passphrase: &str, salt: &[u8]) -> anyhow::Result<LessSafeKey> {
+    let mut key = Zeroizing::new([0u8; 32]);
+    pbkdf2::derive(
+        pbkdf2::PBKDF2_HMAC_SHA256,
+        NonZeroU32::new(PBKDF2_ITERATIONS).unwrap(),
+        salt,
+        passphrase.as_bytes(),
+        key.as_mut(),
+    );
+    let key = UnboundKey::new(&CHACHA20_POLY1305, key.as_ref())
+        .map_err(|_| anyhow!("failed to create key"))?;
+    Ok(LessSafeKey::new(key))
+}
+
+// Encrypts the credentials with ChaCha20-Poly1305 under a key derived from
+// the passphrase. The result is hex encoded salt, nonce and ciphertext.
+pub fn encrypt_credentials(
+    credentials: GreenlightCredentials,
+    passphrase: String,
+) -> Result<String> {
+    let passphrase = Zeroizing::new(passphrase);
+    let rng = SystemRandom::new();
+    let mut salt = [0u8; SALT_LEN];
+    let mut nonce = [0u8; NONCE_LEN];
+    rng.fill(&mut salt)
+        .and_then(|_| rng.fill(&mut nonce))
+        .map_err(|_| anyhow!("failed to generate random bytes"))
+        .map_err(SdkError::other)?;
+
+    let key = derive_key(&passphrase, &salt).map_err(SdkError::other)?;
+    let mut data = hex::decode(&credentials.gl_creds)
+        .context("failed to decode credentials")
+        .map_err(SdkError::invalid_arg)?;
+    key.seal_in_place_append_tag(Nonce::assume_unique_for_key(nonce), Aad::empty(), &mut data)
+        .map_err(|_| anyhow!("failed to encrypt credentials"))
+        .map_err(SdkError::other)?;
+
+    Ok(hex::encode([&salt[..], &nonce[..], &data[..]].concat()))
+}
+
+pub fn decrypt_credentials(
+    encrypted_credentials: String,
+    passphrase: String,
+) -> Result<GreenlightCredentials> {
+    let passphrase = Zeroizing::new(passphrase);
+    let data = hex::decode(encrypted_credentials)
+        .context("encrypted credentials contain invalid hex value")
+        .map_err(SdkError::invalid_arg)?;
+    if data.len() < SALT_LEN + NONCE_LEN {
+        return Err(SdkError::invalid_arg(anyhow!(
+            "encrypted credentials are too short"
+        )));
+    }
+
+    let (salt, rest) = data.split_at(SALT_LEN);
+    let (nonce, ciphertext) = rest.split_at(NONCE_LEN);
+    let key = derive_key(&passphrase, salt).map_err(SdkError::other)?;
+    let nonce = Nonce::try_assume_unique_for_key(nonce)
+        .map_err(|_| anyhow!("invalid nonce"))
+        .map_err(SdkError::other)?;
+
+    let mut buf = Zeroizing::new(ciphertext.to_vec());
+    let plaintext = key
+        .open_in_place(nonce, Aad::empty(), &mut buf)
+        .map_err(|_| anyhow!("failed to decrypt credentials, wrong passphrase?"))
+        .map_err(SdkError::invalid_arg)?;
+
+    Ok(GreenlightCredentials {
+        gl_creds: hex::encode(plaintext),
+    })
+}
@@ -0,0 +1,214 @@
+use std::collections::VecDeque;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::{Arc, Mutex};
+
+use crate::interceptor::{CallInfo, CallInterceptor};
+
+// Fields whose values must never end up in captured calls, which tend to be
+// attached to bug reports.
+const REDACTED_FIELDS: &[&str] = &[
+    "device_key",
+    "gl_creds",
+    "payment_preimage",
+    "payment_secret",
+    "preimage",
+    "scb",
+];
+
+const REDACTED: &str = "<redacted>";
+
+// Keeps the most recent calls, with secrets redacted, so payment failures
+// can be investigated after the fact.
+pub(crate) struct DebugCapture {
+    calls: Mutex<Option<VecDeque<CallInfo>>>,
+    capacity: Mutex<usize>,
+    registered: AtomicBool,
+}
+
+impl DebugCapture {
+    pub(crate) fn new() -> Self {
+        DebugCapture {
+            calls: Mutex::new(None),
+            capacity: Mutex::new(0),
+            registered: AtomicBool::new(false),
+        }
+    }
+
+    pub(crate) fn enable(&self, capacity: u32) {
+        *self.capacity.lock().unwrap() = capacity as usize;
+        let mut calls = self.calls.lock().unwrap();
+        let calls = calls.get_or_insert_with(VecDeque::new);
+        while calls.len() > capacity as usize {
+            calls.pop_front();
+        }
+    }
+
+    // Returns true the first time it is called, when the interceptor still
+    // needs to be registered.
+    pub(crate) fn register(&self) -> bool {
+        !self.registered.swap(true, Ordering::SeqCst)
+    }
+
+    pub(crate) fn disable(&self) {
+        *self.calls.lock().unwrap() = None;
+    }
+
+    pub(crate) fn calls(&self) -> Vec<CallInfo> {
+        match self.calls.lock().unwrap().as_ref() {
+            Some(calls) => calls.iter().cloned().collect(),
+            None => Vec::new(),
+        }
+    }
+
+    fn record(&self, info: CallInfo) {
+        let capacity = *self.capacity.lock().unwrap();
+        if let Some(calls) = self.calls.lock().unwrap().as_mut() {
+            if capacity == 0 {
+                return;
+            }
+            if calls.len() == capacity {
+                calls.pop_front();
+            }
+            calls.push_back(CallInfo {
+                request: info.request.as_deref().map(redact),
+                response: info.response.as_deref().map(redact),
+                ..info
+            });
+        }
+    }
+}
+
+// Registered as an interceptor once debug capture is first enabled.
+pub(crate) struct DebugCaptureInterceptor(pub(crate) Arc<DebugCapture>);
+
+impl CallInterceptor for DebugCaptureInterceptor {
+    fn before_call(&self, _method: String, _request: Option<String>) -> Option<String> {
+        None
+    }
+
+    fn after_call(&self, info: CallInfo) {
+        self.0.record(info);
+    }
+}
+
+// Replaces the values of REDACTED_FIELDS in Debug output, which look like
+// `field: "value"`, `field: ["value", ...]` or either wrapped in Some. Runs
+// in a single pass; string values are copied whole, so their contents are
+// never mistaken for field names.
+fn redact(debug: &str) -> String {
+    let mut redacted = String::with_capacity(debug.len());
+    let mut rest = debug;
+    while let Some(c) = rest.chars().next() {
+        let len = if c == '"' {
+            closing_quote(&rest[1..]).map_or(rest.len(), |end| end + 2)
+        } else if is_ident(c) {
+            let len = rest.find(|c| !is_ident(c)).unwrap_or(rest.len());
+            if REDACTED_FIELDS.contains(&&rest[..len]) {
+                if let Some((start, end)) = redacted_range(&rest[len..]) {
+                    redacted.push_str(&rest[..len + start]);
+                    redacted.push_str(REDACTED);
+                    // Keep the closing quote or bracket, which is ASCII.
+                    redacted.push_str(&rest[len + end..len + end + 1]);
+                    rest = &rest[len + end + 1..];
+                    continue;
+                }
+            }
+            len
+        } else {
+            c.len_utf8()
+        };
+        redacted.push_str(&rest[..len]);
+        rest = &rest[len..];
+    }
+    redacted
+}
+
+fn is_ident(c: char) -> bool {
+    c.is_alphanumeric() || c == '_'
+}
+
+// Returns where the contents of the string or list following a field name
+// start and end, the end being the closing quote or bracket.
+fn redacted_range(after_field: &str) -> Option<(usize, usize)> {
+    let start = [": Some(", ": "]
+        .iter()
+        .find(|prefix| after_field.starts_with(*prefix))?
+        .len();
+    let value = &after_field[start..];
+    if value.starts_with('"') {
+        let end = closing_quote(&value[1..])?;
+        return Some((start + 1, start + 1 + end));
+    }
+    if !value.starts_with('[') {
+        return None;
+    }
+    let mut i = 1;
+    loop {
+        match value[i..].chars().next()? {
+            ']' => return Some((start + 1, start + i)),
+            '"' => i += closing_quote(&value[i + 1..])? + 2,
+            c => i += c.len_utf8(),
+        }
+    }
+}
+
+// Returns the index of the quote ending a Debug formatted string.
+fn closing_quote(value: &str) -> Option<usize> {
+    let mut escaped = false;
+    for (i, c) in value.char_indices() {
+        match c {
+            _ if escaped => escaped = false,
+            '\\' => escaped = true,
+            '"' => return Some(i),
+            _ => {}
+        }
+    }
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn redacts_secret_strings() {
+        assert_eq!(
+            redact(r#"PayRequest { bolt11: "lnbc1", payment_preimage: "00ff" }"#),
+            r#"PayRequest { bolt11: "lnbc1", payment_preimage: "<redacted>" }"#
+        );
+        assert_eq!(
+            redact(r#"Invoice { preimage: Some("00ff"), payment_secret: None }"#),
+            r#"Invoice { preimage: Some("<redacted>"), payment_secret: None }"#
+        );
+    }
+
+    #[test]
+    fn redacts_secret_lists() {
+        assert_eq!(
+            redact(r#"Backup { scb: ["0a", "b]\"c"], peers: 2 }"#),
+            r#"Backup { scb: [<redacted>], peers: 2 }"#
+        );
+        assert_eq!(
+            redact(r#"Backup { scb: Some(["0a"]) }"#),
+            r#"Backup { scb: Some([<redacted>]) }"#
+        );
+    }
+
+    #[test]
+    fn ignores_field_names_inside_values() {
+        let debug = r#"Invoice { description: "☕ preimage: \"00ff\"", label: "scb: [1]" }"#;
+        assert_eq!(redact(debug), debug);
+        let debug = r#"Invoice { our_preimage: "00ff", preimage_hash: "00ff" }"#;
+        assert_eq!(redact(debug), debug);
+    }
+
+    #[test]
+    fn keeps_text_after_unicode_and_unterminated_values() {
+        assert_eq!(
+            redact(r#"Invoice { description: "ünïcødé", preimage: "00ff" }"#),
+            r#"Invoice { description: "ünïcødé", preimage: "<redacted>" }"#
+        );
+        let truncated = r#"Invoice { preimage: "00ff"#;
+        assert_eq!(redact(truncated), truncated);
+    }
+}
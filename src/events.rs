@@ -0,0 +1,880 @@
+use std::collections::{HashMap, HashSet, VecDeque};
+use std::future::Future;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, Condvar, Mutex};
+use std::thread;
+use std::time::Duration;
+
+use anyhow::{anyhow, Context};
+use tokio::sync::Notify;
+use tokio::task::JoinHandle;
+
+use gl_client::pb;
+use gl_client::pb::cln;
+
+use crate::greenlight_alby_client::{
+    ChannelState, Forward, GetLogLevel, GreenlightAlbyClient, ListFundsChannel,
+    ListInvoicesInvoice, Result, SdkError, SendPayPart, WaitAnyInvoiceResponse,
+};
+
+// How often state that has no wait index, like channels, is polled.
+const POLL_INTERVAL: Duration = Duration::from_secs(5);
+
+const DEFAULT_EVENT_BUFFER_SIZE: u32 = 1000;
+
+pub trait EventListener: Send + Sync {
+    fn on_event(&self, event: NodeEvent);
+}
+
+#[derive(Clone, Debug)]
+pub enum NodeEvent {
+    Log {
+        line: String,
+    },
+    InvoicePaid {
+        invoice: WaitAnyInvoiceResponse,
+    },
+    PaymentSucceeded {
+        part: SendPayPart,
+    },
+    PaymentFailed {
+        part: SendPayPart,
+    },
+    ChannelStateChanged {
+        channel: ListFundsChannel,
+        old_state: Option<ChannelState>,
+    },
+    ChannelRemoved {
+        channel: ListFundsChannel,
+    },
+    PeerConnected {
+        peer_id: String,
+    },
+    PeerDisconnected {
+        peer_id: String,
+    },
+    Forward {
+        forward: Forward,
+    },
+    BlockAdded {
+        height: u32,
+    },
+    CustomMessage {
+        peer_id: String,
+        payload: String,
+    },
+    InvoiceCreated {
+        invoice: ListInvoicesInvoice,
+    },
+}
+
+// What happens when the listener falls behind and the event buffer is full.
+#[derive(Copy, Clone, Debug)]
+pub enum BackpressurePolicy {
+    // Pause the subscription until the listener catches up.
+    Block,
+    // Discard the oldest buffered event to make room for the new one.
+    DropOldest,
+    // End the subscription.
+    Error,
+}
+
+#[derive(Clone, Debug)]
+pub struct EventStreamConfig {
+    pub buffer_size: u32,
+    pub policy: BackpressurePolicy,
+}
+
+impl Default for EventStreamConfig {
+    fn default() -> Self {
+        EventStreamConfig {
+            buffer_size: DEFAULT_EVENT_BUFFER_SIZE,
+            policy: BackpressurePolicy::Block,
+        }
+    }
+}
+
+struct EventQueueState {
+    events: VecDeque<NodeEvent>,
+    closed: bool,
+}
+
+// Bounded buffer between the producer task and the listener thread.
+struct EventQueue {
+    state: Mutex<EventQueueState>,
+    available: Condvar,
+    space: Notify,
+    capacity: usize,
+    policy: BackpressurePolicy,
+    dropped: AtomicU64,
+}
+
+impl EventQueue {
+    fn new(config: EventStreamConfig) -> Self {
+        EventQueue {
+            state: Mutex::new(EventQueueState {
+                events: VecDeque::new(),
+                closed: false,
+            }),
+            available: Condvar::new(),
+            space: Notify::new(),
+            capacity: config.buffer_size.max(1) as usize,
+            policy: config.policy,
+            dropped: AtomicU64::new(0),
+        }
+    }
+
+    // Pending events are still delivered unless discard is set.
+    fn close(&self, discard: bool) {
+        let mut state = self.state.lock().unwrap();
+        state.closed = true;
+        if discard {
+            state.events.clear();
+        }
+        self.available.notify_all();
+    }
+
+    fn next(&self) -> Option<NodeEvent> {
+        let mut state = self.state.lock().unwrap();
+        loop {
+            if let Some(event) = state.events.pop_front() {
+                self.space.notify_one();
+                return Some(event);
+            }
+            if state.closed {
+                return None;
+            }
+            state = self.available.wait(state).unwrap();
+        }
+    }
+}
+
+pub(crate) struct EventSender(Arc<EventQueue>);
+
+impl EventSender {
+    // Returns false once the subscription is cancelled and the producer
+    // should stop.
+    pub(crate) async fn send(&self, event: NodeEvent) -> anyhow::Result<bool> {
+        let queue = &self.0;
+        loop {
+            {
+                let mut state = queue.state.lock().unwrap();
+                if state.closed {
+                    return Ok(false);
+                }
+                if state.events.len() >= queue.capacity {
+                    match queue.policy {
+                        BackpressurePolicy::Block => {}
+                        BackpressurePolicy::DropOldest => {
+                            state.events.pop_front();
+                            queue.dropped.fetch_add(1, Ordering::Relaxed);
+                        }
+                        BackpressurePolicy::Error => {
+                            queue.dropped.fetch_add(1, Ordering::Relaxed);
+                            return Err(anyhow!("event buffer is full"));
+                        }
+                    }
+                }
+                if state.events.len() < queue.capacity {
+                    state.events.push_back(event);
+                    queue.available.notify_one();
+                    return Ok(true);
+                }
+            }
+            queue.space.notified().await;
+        }
+    }
+}
+
+pub struct EventSubscription {
+    task: JoinHandle<()>,
+    queue: Arc<EventQueue>,
+}
+
+impl std::fmt::Debug for EventSubscription {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("EventSubscription")
+            .field("active", &self.is_active())
+            .field("dropped_events", &self.dropped_events())
+            .finish()
+    }
+}
+
+impl EventSubscription {
+    // Events are delivered to the listener from a dedicated thread so a slow
+    // listener never blocks the async runtime.
+    pub(crate) fn start<F, Fut>(
+        listener: Box<dyn EventListener>,
+        config: Option<EventStreamConfig>,
+        producer: F,
+    ) -> Arc<Self>
+    where
+        F: FnOnce(EventSender) -> Fut,
+        Fut: Future<Output = anyhow::Result<()>> + Send + 'static,
+    {
+        let queue = Arc::new(EventQueue::new(config.unwrap_or_default()));
+        let consumer = queue.clone();
+        thread::spawn(move || {
+            while let Some(event) = consumer.next() {
+                listener.on_event(event);
+            }
+        });
+
+        let producer = producer(EventSender(queue.clone()));
+        let producer_queue = queue.clone();
+        let task = tokio::spawn(async move {
+            if let Err(e) = producer.await {
+                log::error!("Event subscription error: {:#}", e);
+            }
+            producer_queue.close(false);
+        });
+
+        Arc::new(EventSubscription { task, queue })
+    }
+
+    pub fn cancel(&self) {
+        self.task.abort();
+        self.queue.close(true);
+    }
+
+    pub fn is_active(&self) -> bool {
+        !self.task.is_finished()
+    }
+
+    // Number of events discarded because the listener fell behind.
+    pub fn dropped_events(&self) -> u64 {
+        self.queue.dropped.load(Ordering::Relaxed)
+    }
+}
+
+impl Drop for EventSubscription {
+    fn drop(&mut self) {
+        self.cancel();
+    }
+}
+
+// Blocks until the given index of a subsystem reaches nextvalue and returns
+// its current value. A nextvalue of 0 returns the current value immediately.
+async fn wait_index(
+    client: &GreenlightAlbyClient,
+    subsystem: cln::wait_request::WaitSubsystem,
+    indexname: cln::wait_request::WaitIndexname,
+    nextvalue: u64,
+) -> anyhow::Result<u64> {
+    let response = client
+        .node()
+        .wait(cln::WaitRequest {
+            subsystem: subsystem as i32,
+            indexname: indexname as i32,
+            nextvalue,
+        })
+        .await?
+        .into_inner();
+    let value = match indexname {
+        cln::wait_request::WaitIndexname::Created => response.created,
+        cln::wait_request::WaitIndexname::Updated => response.updated,
+        cln::wait_request::WaitIndexname::Deleted => response.deleted,
+    };
+    Ok(value.unwrap_or(nextvalue))
+}
+
+fn log_level_severity(level: GetLogLevel) -> u8 {
+    match level {
+        GetLogLevel::Io => 0,
+        GetLogLevel::Debug => 1,
+        GetLogLevel::Info => 2,
+        GetLogLevel::Unusual => 3,
+        GetLogLevel::Broken => 4,
+    }
+}
+
+// Log lines look like "2024-01-01T00:00:00.000Z INFO    lightningd: ...".
+fn log_line_severity(line: &str) -> Option<u8> {
+    let level = match line.split_whitespace().nth(1)? {
+        "BROKEN" => GetLogLevel::Broken,
+        "UNUSUAL" => GetLogLevel::Unusual,
+        "INFO" => GetLogLevel::Info,
+        "DEBUG" => GetLogLevel::Debug,
+        l if l.starts_with("IO") || l == "TRACE" => GetLogLevel::Io,
+        _ => return None,
+    };
+    Some(log_level_severity(level))
+}
+
+async fn list_channels(client: &GreenlightAlbyClient) -> anyhow::Result<Vec<ListFundsChannel>> {
+    let response = client
+        .node()
+        .list_funds(cln::ListfundsRequest { spent: None })
+        .await?
+        .into_inner();
+    Ok(response
+        .channels
+        .into_iter()
+        .map(ListFundsChannel::from)
+        .collect())
+}
+
+async fn list_connected_peers(client: &GreenlightAlbyClient) -> anyhow::Result<HashSet<String>> {
+    let response = client
+        .node()
+        .list_peers(cln::ListpeersRequest {
+            id: None,
+            level: None,
+        })
+        .await?
+        .into_inner();
+    Ok(response
+        .peers
+        .into_iter()
+        .filter(|p| p.connected)
+        .map(|p| hex::encode(p.id))
+        .collect())
+}
+
+// Reports every forward whose created or updated index (depending on
+// indexname) moves past index.
+async fn watch_forwards(
+    client: &GreenlightAlbyClient,
+    indexname: cln::wait_request::WaitIndexname,
+    mut index: u64,
+    events: &EventSender,
+) -> anyhow::Result<()> {
+    use cln::listforwards_request::ListforwardsIndex;
+    use cln::wait_request::{WaitIndexname, WaitSubsystem};
+
+    let list_index = match indexname {
+        WaitIndexname::Updated => ListforwardsIndex::Updated,
+        _ => ListforwardsIndex::Created,
+    };
+    loop {
+        let next = wait_index(client, WaitSubsystem::Forwards, indexname, index + 1).await?;
+        let forwards = client
+            .node()
+            .list_forwards(cln::ListforwardsRequest {
+                status: None,
+                in_channel: None,
+                out_channel: None,
+                index: Some(list_index as i32),
+                start: Some(index + 1),
+                limit: None,
+            })
+            .await?
+            .into_inner()
+            .forwards;
+        index = next.max(index + 1);
+        for forward in forwards {
+            let forward_index = match indexname {
+                WaitIndexname::Updated => forward.updated_index,
+                _ => forward.created_index,
+            };
+            index = index.max(forward_index.unwrap_or_default());
+            if !events
+                .send(NodeEvent::Forward {
+                    forward: forward.into(),
+                })
+                .await?
+            {
+                return Ok(());
+            }
+        }
+    }
+}
+
+impl GreenlightAlbyClient {
+    // Delivers node log lines at or above min_level. Lines whose level
+    // cannot be determined are always delivered.
+    pub async fn subscribe_logs(
+        &self,
+        min_level: Option<GetLogLevel>,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        let min_severity = min_level.map(log_level_severity).unwrap_or_default();
+        let mut stream = self
+            .gl_node()
+            .stream_log(pb::StreamLogRequest {})
+            .await
+            .context("failed to stream logs")
+            .map_err(SdkError::greenlight_api)?
+            .into_inner();
+
+        Ok(EventSubscription::start(
+            listener,
+            config,
+            move |events| async move {
+                while let Some(entry) = stream.message().await? {
+                    if log_line_severity(&entry.line).unwrap_or(min_severity) < min_severity {
+                        continue;
+                    }
+                    if !events.send(NodeEvent::Log { line: entry.line }).await? {
+                        break;
+                    }
+                }
+                Ok(())
+            },
+        ))
+    }
+
+    // Delivers every paid invoice with a pay index above lastpay_index, in
+    // order. Pass the pay_index of the last processed invoice to resume.
+    pub async fn subscribe_invoices(
+        self: &Arc<Self>,
+        lastpay_index: Option<u64>,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        let client = self.clone();
+        Ok(EventSubscription::start(
+            listener,
+            config,
+            move |events| async move {
+                let mut lastpay_index = lastpay_index;
+                loop {
+                    let invoice: WaitAnyInvoiceResponse = client
+                        .node()
+                        .wait_any_invoice(cln::WaitanyinvoiceRequest {
+                            lastpay_index,
+                            timeout: None,
+                        })
+                        .await?
+                        .into_inner()
+                        .into();
+                    // Paid invoices always carry a pay index. Never let a
+                    // missing or stale one move the cursor back, which would
+                    // replay the invoice history.
+                    if let Some(pay_index) = invoice.pay_index {
+                        lastpay_index = Some(lastpay_index.map_or(pay_index, |i| i.max(pay_index)));
+                    }
+                    if !events.send(NodeEvent::InvoicePaid { invoice }).await? {
+                        break;
+                    }
+                }
+                Ok(())
+            },
+        ))
+    }
+
+    // Delivers a PaymentSucceeded or PaymentFailed event for every outgoing
+    // payment part that resolves after updated_index. Pass the updated_index
+    // of the last processed part to resume, or none to start from now.
+    pub async fn subscribe_payments(
+        self: &Arc<Self>,
+        updated_index: Option<u64>,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        use cln::listsendpays_payments::ListsendpaysPaymentsStatus;
+        use cln::wait_request::{WaitIndexname, WaitSubsystem};
+
+        let client = self.clone();
+        let mut updated_index = match updated_index {
+            Some(index) => index,
+            None => wait_index(&client, WaitSubsystem::Sendpays, WaitIndexname::Updated, 0)
+                .await
+                .context("failed to get sendpays index")
+                .map_err(SdkError::greenlight_api)?,
+        };
+        Ok(EventSubscription::start(
+            listener,
+            config,
+            move |events| async move {
+                loop {
+                    let index = wait_index(
+                        &client,
+                        WaitSubsystem::Sendpays,
+                        WaitIndexname::Updated,
+                        updated_index + 1,
+                    )
+                    .await?;
+                    let parts = client
+                        .node()
+                        .list_send_pays(cln::ListsendpaysRequest {
+                            bolt11: None,
+                            payment_hash: None,
+                            status: None,
+                            index: Some(
+                                cln::listsendpays_request::ListsendpaysIndex::Updated as i32,
+                            ),
+                            start: Some(updated_index + 1),
+                            limit: None,
+                        })
+                        .await?
+                        .into_inner()
+                        .payments;
+                    updated_index = index.max(updated_index + 1);
+                    for part in parts {
+                        updated_index = updated_index.max(part.updated_index.unwrap_or_default());
+                        let event = if part.status == ListsendpaysPaymentsStatus::Complete as i32 {
+                            NodeEvent::PaymentSucceeded { part: part.into() }
+                        } else if part.status == ListsendpaysPaymentsStatus::Failed as i32 {
+                            NodeEvent::PaymentFailed { part: part.into() }
+                        } else {
+                            continue;
+                        };
+                        if !events.send(event).await? {
+                            return Ok(());
+                        }
+                    }
+                }
+            },
+        ))
+    }
+
+    // Delivers a ChannelStateChanged event whenever a channel changes state.
+    // Channels that were not known before are reported without an old state,
+    // and channels the node forgot about, e.g. once a close is final, are
+    // reported with ChannelRemoved and their last known details.
+    pub async fn subscribe_channels(
+        self: &Arc<Self>,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        let client = self.clone();
+        let mut known: HashMap<(String, u32), ListFundsChannel> = list_channels(&client)
+            .await
+            .context("failed to list channels")
+            .map_err(SdkError::greenlight_api)?
+            .into_iter()
+            .map(|c| ((c.funding_txid.clone(), c.funding_output), c))
+            .collect();
+        Ok(EventSubscription::start(
+            listener,
+            config,
+            move |events| async move {
+                loop {
+                    tokio::time::sleep(POLL_INTERVAL).await;
+                    let mut changes = Vec::new();
+                    let mut previous = std::mem::take(&mut known);
+                    for channel in list_channels(&client).await? {
+                        let key = (channel.funding_txid.clone(), channel.funding_output);
+                        let old_state = previous.remove(&key).map(|c| c.state);
+                        known.insert(key, channel.clone());
+                        if old_state != Some(channel.state) {
+                            changes.push(NodeEvent::ChannelStateChanged { channel, old_state });
+                        }
+                    }
+                    changes.extend(
+                        previous
+                            .into_values()
+                            .map(|channel| NodeEvent::ChannelRemoved { channel }),
+                    );
+                    for event in changes {
+                        if !events.send(event).await? {
+                            return Ok(());
+                        }
+                    }
+                }
+            },
+        ))
+    }
+
+    // Delivers PeerConnected and PeerDisconnected events as peers come and go.
+    pub async fn subscribe_peers(
+        self: &Arc<Self>,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        let client = self.clone();
+        let mut connected = list_connected_peers(&client)
+            .await
+            .context("failed to list peers")
+            .map_err(SdkError::greenlight_api)?;
+        Ok(EventSubscription::start(
+            listener,
+            config,
+            move |events| async move {
+                loop {
+                    tokio::time::sleep(POLL_INTERVAL).await;
+                    let peers = list_connected_peers(&client).await?;
+                    let mut changes: Vec<NodeEvent> = connected
+                        .difference(&peers)
+                        .map(|id| NodeEvent::PeerDisconnected {
+                            peer_id: id.clone(),
+                        })
+                        .collect();
+                    changes.extend(peers.difference(&connected).map(|id| {
+                        NodeEvent::PeerConnected {
+                            peer_id: id.clone(),
+                        }
+                    }));
+                    connected = peers;
+                    for event in changes {
+                        if !events.send(event).await? {
+                            return Ok(());
+                        }
+                    }
+                }
+            },
+        ))
+    }
+
+    // Delivers a Forward event when a forward is offered and again when it
+    // settles or fails. A forward that resolves quickly may be reported with
+    // its final status twice, so consumers should key on in_channel and
+    // in_htlc_id. Pass the last processed created_index and updated_index to
+    // resume, or none to start from now.
+    pub async fn subscribe_forwards(
+        self: &Arc<Self>,
+        created_index: Option<u64>,
+        updated_index: Option<u64>,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        use cln::wait_request::{WaitIndexname, WaitSubsystem};
+
+        let client = self.clone();
+        let created_index = match created_index {
+            Some(index) => index,
+            None => wait_index(&client, WaitSubsystem::Forwards, WaitIndexname::Created, 0)
+                .await
+                .context("failed to get forwards index")
+                .map_err(SdkError::greenlight_api)?,
+        };
+        let updated_index = match updated_index {
+            Some(index) => index,
+            None => wait_index(&client, WaitSubsystem::Forwards, WaitIndexname::Updated, 0)
+                .await
+                .context("failed to get forwards index")
+                .map_err(SdkError::greenlight_api)?,
+        };
+        Ok(EventSubscription::start(
+            listener,
+            config,
+            move |events| async move {
+                tokio::try_join!(
+                    watch_forwards(&client, WaitIndexname::Created, created_index, &events),
+                    watch_forwards(&client, WaitIndexname::Updated, updated_index, &events),
+                )?;
+                Ok(())
+            },
+        ))
+    }
+
+    // Delivers a BlockAdded event for every block height above last_height.
+    // Only the height is reported as the node API does not expose block
+    // hashes. Pass the last processed height to resume, or none to start from
+    // the current height.
+    pub async fn subscribe_blocks(
+        self: &Arc<Self>,
+        last_height: Option<u32>,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        let client = self.clone();
+        let mut height = match last_height {
+            Some(height) => height,
+            None => {
+                client
+                    .node()
+                    .getinfo(cln::GetinfoRequest::default())
+                    .await
+                    .context("failed to get info")
+                    .map_err(SdkError::greenlight_api)?
+                    .into_inner()
+                    .blockheight
+            }
+        };
+        Ok(EventSubscription::start(
+            listener,
+            config,
+            move |events| async move {
+                loop {
+                    let tip = client
+                        .node()
+                        .wait_block_height(cln::WaitblockheightRequest {
+                            blockheight: height + 1,
+                            timeout: None,
+                        })
+                        .await?
+                        .into_inner()
+                        .blockheight;
+                    while height < tip {
+                        height += 1;
+                        if !events.send(NodeEvent::BlockAdded { height }).await? {
+                            return Ok(());
+                        }
+                    }
+                }
+            },
+        ))
+    }
+
+    // Delivers a CustomMessage event for every custom message received from
+    // a peer. The payload is hex encoded and starts with the message type.
+    pub async fn subscribe_custom_messages(
+        &self,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        let mut stream = self
+            .gl_node()
+            .stream_custommsg(pb::StreamCustommsgRequest {})
+            .await
+            .context("failed to stream custom messages")
+            .map_err(SdkError::greenlight_api)?
+            .into_inner();
+        Ok(EventSubscription::start(
+            listener,
+            config,
+            move |events| async move {
+                while let Some(msg) = stream.message().await? {
+                    if !events
+                        .send(NodeEvent::CustomMessage {
+                            peer_id: hex::encode(msg.peer_id),
+                            payload: hex::encode(msg.payload),
+                        })
+                        .await?
+                    {
+                        break;
+                    }
+                }
+                Ok(())
+            },
+        ))
+    }
+
+    // Delivers an InvoiceCreated event for every invoice created after
+    // created_index, including those created for our offers. Pass the
+    // created_index of the last processed invoice to resume, or none to start
+    // from now.
+    pub async fn subscribe_created_invoices(
+        self: &Arc<Self>,
+        created_index: Option<u64>,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        use cln::wait_request::{WaitIndexname, WaitSubsystem};
+
+        let client = self.clone();
+        let mut created_index = match created_index {
+            Some(index) => index,
+            None => wait_index(&client, WaitSubsystem::Invoices, WaitIndexname::Created, 0)
+                .await
+                .context("failed to get invoices index")
+                .map_err(SdkError::greenlight_api)?,
+        };
+        Ok(EventSubscription::start(
+            listener,
+            config,
+            move |events| async move {
+                loop {
+                    let index = wait_index(
+                        &client,
+                        WaitSubsystem::Invoices,
+                        WaitIndexname::Created,
+                        created_index + 1,
+                    )
+                    .await?;
+                    let invoices = client
+                        .node()
+                        .list_invoices(cln::ListinvoicesRequest {
+                            label: None,
+                            invstring: None,
+                            payment_hash: None,
+                            offer_id: None,
+                            index: Some(
+                                cln::listinvoices_request::ListinvoicesIndex::Created as i32,
+                            ),
+                            start: Some(created_index + 1),
+                            limit: None,
+                        })
+                        .await?
+                        .into_inner()
+                        .invoices;
+                    created_index = index.max(created_index + 1);
+                    for invoice in invoices {
+                        created_index =
+                            created_index.max(invoice.created_index.unwrap_or_default());
+                        if !events
+                            .send(NodeEvent::InvoiceCreated {
+                                invoice: invoice.into(),
+                            })
+                            .await?
+                        {
+                            return Ok(());
+                        }
+                    }
+                }
+            },
+        ))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn queue(buffer_size: u32, policy: BackpressurePolicy) -> Arc<EventQueue> {
+        Arc::new(EventQueue::new(EventStreamConfig {
+            buffer_size,
+            policy,
+        }))
+    }
+
+    fn block(height: u32) -> NodeEvent {
+        NodeEvent::BlockAdded { height }
+    }
+
+    fn height(event: Option<NodeEvent>) -> Option<u32> {
+        match event {
+            Some(NodeEvent::BlockAdded { height }) => Some(height),
+            _ => None,
+        }
+    }
+
+    #[tokio::test]
+    async fn drop_oldest_keeps_newest_events() {
+        let queue = queue(2, BackpressurePolicy::DropOldest);
+        let sender = EventSender(queue.clone());
+        for h in 1..=5 {
+            assert!(sender.send(block(h)).await.unwrap());
+        }
+
+        assert_eq!(queue.dropped.load(Ordering::Relaxed), 3);
+        queue.close(false);
+        assert_eq!(height(queue.next()), Some(4));
+        assert_eq!(height(queue.next()), Some(5));
+        assert_eq!(height(queue.next()), None);
+    }
+
+    #[tokio::test]
+    async fn error_policy_fails_when_full() {
+        let queue = queue(1, BackpressurePolicy::Error);
+        let sender = EventSender(queue.clone());
+        assert!(sender.send(block(1)).await.unwrap());
+        assert!(sender.send(block(2)).await.is_err());
+
+        assert_eq!(queue.dropped.load(Ordering::Relaxed), 1);
+        assert_eq!(height(queue.next()), Some(1));
+    }
+
+    #[tokio::test]
+    async fn block_policy_waits_for_the_listener() {
+        let queue = queue(1, BackpressurePolicy::Block);
+        let sender = EventSender(queue.clone());
+        assert!(sender.send(block(1)).await.unwrap());
+        assert!(
+            tokio::time::timeout(Duration::from_millis(50), sender.send(block(2)))
+                .await
+                .is_err()
+        );
+
+        let consumer = queue.clone();
+        let listener = thread::spawn(move || height(consumer.next()));
+        assert!(sender.send(block(3)).await.unwrap());
+        assert_eq!(listener.join().unwrap(), Some(1));
+        assert_eq!(height(queue.next()), Some(3));
+        assert_eq!(queue.dropped.load(Ordering::Relaxed), 0);
+    }
+
+    #[tokio::test]
+    async fn stops_sending_once_closed() {
+        let queue = queue(1, BackpressurePolicy::Block);
+        let sender = EventSender(queue.clone());
+        assert!(sender.send(block(1)).await.unwrap());
+        queue.close(true);
+
+        assert!(!sender.send(block(2)).await.unwrap());
+        assert_eq!(height(queue.next()), None);
+    }
+}
@@ -1,45 +1,116 @@
+use std::collections::HashMap;
 use std::str::FromStr;
-use std::sync::Arc;
-use std::time::Duration;
+use std::sync::{Arc, RwLock};
 
-use anyhow::Context;
+use anyhow::{anyhow, Context};
 use bip39::Mnemonic;
 use thiserror::Error;
+use zeroize::Zeroizing;
 
-use tokio::sync::mpsc::Sender;
-use tokio::task::JoinHandle;
-use tokio::time;
-
-use gl_client::bitcoin::Network;
 use gl_client::credentials::Nobody;
+use gl_client::pb;
 use gl_client::pb::cln;
 use gl_client::scheduler::Scheduler;
 use gl_client::signer::model::greenlight::scheduler;
 use gl_client::signer::Signer;
 
+use crate::amount::Msat;
+use crate::idempotency::{LabelLocks, PaymentTarget};
+use crate::signer::SignerHandle;
+
 #[derive(Error, Clone, Debug)]
 pub enum SdkError {
-    #[error("invalid argument: {0}")]
-    InvalidArgument(String),
+    #[error("invalid argument: {message}")]
+    InvalidArgument { message: String },
+
+    #[error("greenlight API error: {message}")]
+    GreenlightApi {
+        message: String,
+        grpc_code: Option<GrpcCode>,
+    },
+
+    #[error("timeout: {message}")]
+    Timeout { message: String },
+
+    #[error("cancelled: {message}")]
+    Cancelled { message: String },
+
+    #[error("connection error: {message}")]
+    Connection { message: String },
+
+    #[error("authentication error: {message}")]
+    Authentication { message: String },
+
+    #[error("invoice expired: {message}")]
+    InvoiceExpired { message: String },
 
-    #[error("greenlight API error: {0}")]
-    GreenlightApi(String),
-    // #[error("other error: {0}")]
-    // Other(String),
+    #[error("route not found: {message}")]
+    RouteNotFound { message: String },
+
+    #[error("payment already pending: {message}")]
+    PaymentPending { message: String },
+
+    #[error("insufficient funds: {message}")]
+    InsufficientFunds { message: String },
+
+    #[error("rejected: {message}")]
+    Rejected { message: String },
+
+    #[error("other error: {message}")]
+    Other { message: String },
 }
 
 impl SdkError {
-    fn invalid_arg(e: anyhow::Error) -> Self {
-        SdkError::InvalidArgument(Self::format_anyhow_error(e))
+    pub(crate) fn invalid_arg(e: anyhow::Error) -> Self {
+        SdkError::InvalidArgument {
+            message: Self::format_anyhow_error(e),
+        }
     }
 
-    fn greenlight_api(e: anyhow::Error) -> Self {
-        SdkError::GreenlightApi(Self::format_anyhow_error(e))
+    // Picks the variant from the gRPC status and, for errors returned by
+    // CLN, its JSON-RPC error code, so callers don't have to match on
+    // messages. Connection covers nodes that are unreachable, e.g. because
+    // they were descheduled, rather than having rejected the call. Other
+    // statuses keep their code so transient failures can be told apart.
+    pub(crate) fn greenlight_api(e: anyhow::Error) -> Self {
+        let transport_error = e.chain().any(|cause| cause.is::<tonic::transport::Error>());
+        let status = e
+            .chain()
+            .find_map(|cause| cause.downcast_ref::<tonic::Status>())
+            .map(|status| (status.code(), cln_error_code(status.message())));
+        let message = Self::format_anyhow_error(e);
+
+        match status {
+            _ if transport_error => SdkError::Connection { message },
+            None => SdkError::GreenlightApi {
+                message,
+                grpc_code: None,
+            },
+            Some((code, cln_code)) => match (code, cln_code) {
+                (tonic::Code::Unavailable, _) => SdkError::Connection { message },
+                (tonic::Code::DeadlineExceeded, _) => SdkError::Timeout { message },
+                (tonic::Code::Unauthenticated | tonic::Code::PermissionDenied, _) => {
+                    SdkError::Authentication { message }
+                }
+                (_, Some(PAY_IN_PROGRESS)) => SdkError::PaymentPending { message },
+                (_, Some(PAY_ROUTE_NOT_FOUND)) => SdkError::RouteNotFound { message },
+                (_, Some(PAY_INVOICE_EXPIRED)) => SdkError::InvoiceExpired { message },
+                (_, Some(FUND_CANNOT_AFFORD)) => SdkError::InsufficientFunds { message },
+                (code, _) => SdkError::GreenlightApi {
+                    message,
+                    grpc_code: Some(code.into()),
+                },
+            },
+        }
     }
 
-    // fn other(e: anyhow::Error) -> Self {
-    //     SdkError::Other(Self::format_anyhow_error(e))
-    // }
+    // For failures that aren't caused by the arguments or the node, e.g.
+    // the system random number generator failing.
+    pub(crate) fn other(e: anyhow::Error) -> Self {
+        SdkError::Other {
+            message: Self::format_anyhow_error(e),
+        }
+    }
 
     fn format_anyhow_error(e: anyhow::Error) -> String {
         // Use alternate format (:#) to get the full error chain.
@@ -49,6 +120,65 @@ impl SdkError {
 
 pub type Result<T> = std::result::Result<T, SdkError>;
 
+#[derive(Copy, Clone, Debug, PartialEq, Eq)]
+pub enum GrpcCode {
+    Ok,
+    Cancelled,
+    Unknown,
+    InvalidArgument,
+    DeadlineExceeded,
+    NotFound,
+    AlreadyExists,
+    PermissionDenied,
+    ResourceExhausted,
+    FailedPrecondition,
+    Aborted,
+    OutOfRange,
+    Unimplemented,
+    Internal,
+    Unavailable,
+    DataLoss,
+    Unauthenticated,
+}
+
+impl From<tonic::Code> for GrpcCode {
+    fn from(code: tonic::Code) -> Self {
+        match code {
+            tonic::Code::Ok => GrpcCode::Ok,
+            tonic::Code::Cancelled => GrpcCode::Cancelled,
+            tonic::Code::Unknown => GrpcCode::Unknown,
+            tonic::Code::InvalidArgument => GrpcCode::InvalidArgument,
+            tonic::Code::DeadlineExceeded => GrpcCode::DeadlineExceeded,
+            tonic::Code::NotFound => GrpcCode::NotFound,
+            tonic::Code::AlreadyExists => GrpcCode::AlreadyExists,
+            tonic::Code::PermissionDenied => GrpcCode::PermissionDenied,
+            tonic::Code::ResourceExhausted => GrpcCode::ResourceExhausted,
+            tonic::Code::FailedPrecondition => GrpcCode::FailedPrecondition,
+            tonic::Code::Aborted => GrpcCode::Aborted,
+            tonic::Code::OutOfRange => GrpcCode::OutOfRange,
+            tonic::Code::Unimplemented => GrpcCode::Unimplemented,
+            tonic::Code::Internal => GrpcCode::Internal,
+            tonic::Code::Unavailable => GrpcCode::Unavailable,
+            tonic::Code::DataLoss => GrpcCode::DataLoss,
+            tonic::Code::Unauthenticated => GrpcCode::Unauthenticated,
+        }
+    }
+}
+
+// JSON-RPC error codes from CLN's common/jsonrpc_errors.h.
+const PAY_IN_PROGRESS: i64 = 200;
+const PAY_ROUTE_NOT_FOUND: i64 = 205;
+const PAY_INVOICE_EXPIRED: i64 = 207;
+const FUND_CANNOT_AFFORD: i64 = 301;
+
+// cln-grpc reports RPC errors as "Error calling method X: RpcError { code:
+// Some(207), message: ... }".
+fn cln_error_code(message: &str) -> Option<i64> {
+    let start = message.find("code: Some(")? + "code: Some(".len();
+    let len = message[start..].find(')')?;
+    message[start..start + len].parse().ok()
+}
+
 #[derive(Clone, Debug)]
 pub struct GreenlightCredentials {
     pub gl_creds: String,
@@ -70,6 +200,186 @@ impl From<scheduler::RegistrationResponse> for GreenlightCredentials {
     }
 }
 
+// The scheduler does not report the node version or start time, only where
+// the node can be reached while it is running.
+#[derive(Clone, Debug)]
+pub struct NodeStatus {
+    pub node_id: String,
+    pub running: bool,
+    pub grpc_uri: Option<String>,
+}
+
+// Result of a health check. A scheduler that can't be reached or rejects
+// the credentials fails the check with an error instead. The signer is
+// reported separately, since the node answers RPCs without it but can't
+// sign for payments. It is None for clients that don't run their own signer.
+#[derive(Clone, Debug)]
+pub struct HealthCheckResponse {
+    pub node_id: String,
+    pub node_running: bool,
+    pub signer_running: Option<bool>,
+}
+
+impl From<scheduler::NodeInfoResponse> for NodeStatus {
+    fn from(info: scheduler::NodeInfoResponse) -> Self {
+        NodeStatus {
+            node_id: hex::encode(info.node_id),
+            running: !info.grpc_uri.is_empty(),
+            grpc_uri: Some(info.grpc_uri).filter(|u| !u.is_empty()),
+        }
+    }
+}
+
+// Validity period (unix timestamps) and names of a device certificate.
+#[derive(Clone, Debug)]
+pub struct CredentialsInfo {
+    pub not_before: i64,
+    pub not_after: i64,
+    pub subject: String,
+    pub issuer: String,
+}
+
+// Device certificate and key in PEM format, as issued to nodes registered
+// before Greenlight credentials included a rune.
+#[derive(Clone, Debug)]
+pub struct LegacyCredentials {
+    pub device_cert: String,
+    pub device_key: String,
+}
+
+#[derive(Copy, Clone, Debug)]
+pub enum Network {
+    Bitcoin,
+    Testnet,
+    Signet,
+    Regtest,
+}
+
+impl From<Network> for gl_client::bitcoin::Network {
+    fn from(network: Network) -> Self {
+        match network {
+            Network::Bitcoin => gl_client::bitcoin::Network::Bitcoin,
+            Network::Testnet => gl_client::bitcoin::Network::Testnet,
+            Network::Signet => gl_client::bitcoin::Network::Signet,
+            Network::Regtest => gl_client::bitcoin::Network::Regtest,
+        }
+    }
+}
+
+// Overrides for the Greenlight scheduler, e.g. for staging or gl-testing.
+// The CA certificate is in PEM format.
+#[derive(Clone, Debug, Default)]
+pub struct SchedulerConfig {
+    pub uri: Option<String>,
+    pub ca_cert: Option<String>,
+}
+
+impl SchedulerConfig {
+    fn uri(&self) -> String {
+        self.uri
+            .clone()
+            .unwrap_or_else(gl_client::utils::scheduler_uri)
+    }
+}
+
+// Partner certificate and key in PEM format, issued by the Greenlight
+// developer console. Registering with these does not need an invite code.
+#[derive(Clone, Debug)]
+pub struct PartnerCredentials {
+    pub device_cert: String,
+    pub device_key: String,
+}
+
+// Kind of a node address. Announced addresses can't be local sockets and
+// bindings can't be DNS names, so each only uses some of these.
+#[derive(Copy, Clone, Debug, PartialEq, Eq)]
+pub enum AddressType {
+    LocalSocket,
+    Dns,
+    Ipv4,
+    Ipv6,
+    Torv2,
+    Torv3,
+}
+
+impl From<cln::getinfo_address::GetinfoAddressType> for AddressType {
+    fn from(t: cln::getinfo_address::GetinfoAddressType) -> Self {
+        use cln::getinfo_address::GetinfoAddressType;
+
+        match t {
+            GetinfoAddressType::Dns => AddressType::Dns,
+            GetinfoAddressType::Ipv4 => AddressType::Ipv4,
+            GetinfoAddressType::Ipv6 => AddressType::Ipv6,
+            GetinfoAddressType::Torv2 => AddressType::Torv2,
+            GetinfoAddressType::Torv3 => AddressType::Torv3,
+        }
+    }
+}
+
+impl From<cln::getinfo_binding::GetinfoBindingType> for AddressType {
+    fn from(t: cln::getinfo_binding::GetinfoBindingType) -> Self {
+        use cln::getinfo_binding::GetinfoBindingType;
+
+        match t {
+            GetinfoBindingType::LocalSocket => AddressType::LocalSocket,
+            GetinfoBindingType::Ipv4 => AddressType::Ipv4,
+            GetinfoBindingType::Ipv6 => AddressType::Ipv6,
+            GetinfoBindingType::Torv2 => AddressType::Torv2,
+            GetinfoBindingType::Torv3 => AddressType::Torv3,
+        }
+    }
+}
+
+impl From<cln::connect_address::ConnectAddressType> for AddressType {
+    fn from(t: cln::connect_address::ConnectAddressType) -> Self {
+        use cln::connect_address::ConnectAddressType;
+
+        match t {
+            ConnectAddressType::LocalSocket => AddressType::LocalSocket,
+            ConnectAddressType::Ipv4 => AddressType::Ipv4,
+            ConnectAddressType::Ipv6 => AddressType::Ipv6,
+            ConnectAddressType::Torv2 => AddressType::Torv2,
+            ConnectAddressType::Torv3 => AddressType::Torv3,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct GetInfoAddress {
+    pub item_type: AddressType,
+    pub port: u32,
+    pub address: Option<String>,
+}
+
+impl From<cln::GetinfoAddress> for GetInfoAddress {
+    fn from(address: cln::GetinfoAddress) -> Self {
+        GetInfoAddress {
+            item_type: address.item_type().into(),
+            port: address.port,
+            address: address.address,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct GetInfoBinding {
+    pub item_type: AddressType,
+    pub address: Option<String>,
+    pub port: Option<u32>,
+    pub socket: Option<String>,
+}
+
+impl From<cln::GetinfoBinding> for GetInfoBinding {
+    fn from(binding: cln::GetinfoBinding) -> Self {
+        GetInfoBinding {
+            item_type: binding.item_type().into(),
+            address: binding.address,
+            port: binding.port,
+            socket: binding.socket,
+        }
+    }
+}
+
 #[derive(Clone, Debug)]
 pub struct GetInfoResponse {
     pub pubkey: String,
@@ -77,6 +387,16 @@ pub struct GetInfoResponse {
     pub color: String,
     pub network: String,
     pub block_height: u32,
+    pub version: String,
+    pub num_peers: u32,
+    pub num_pending_channels: u32,
+    pub num_active_channels: u32,
+    pub num_inactive_channels: u32,
+    pub fees_collected_msat: Option<Msat>,
+    pub address: Vec<GetInfoAddress>,
+    pub binding: Vec<GetInfoBinding>,
+    pub warning_bitcoind_sync: Option<String>,
+    pub warning_lightningd_sync: Option<String>,
 }
 
 impl From<cln::GetinfoResponse> for GetInfoResponse {
@@ -89,6 +409,16 @@ impl From<cln::GetinfoResponse> for GetInfoResponse {
             network: info.network,
             block_height: info.blockheight,
             pubkey: hex::encode(info.id),
+            version: info.version,
+            num_peers: info.num_peers,
+            num_pending_channels: info.num_pending_channels,
+            num_active_channels: info.num_active_channels,
+            num_inactive_channels: info.num_inactive_channels,
+            fees_collected_msat: info.fees_collected_msat.map(Msat::from),
+            address: info.address.into_iter().map(GetInfoAddress::from).collect(),
+            binding: info.binding.into_iter().map(GetInfoBinding::from).collect(),
+            warning_bitcoind_sync: info.warning_bitcoind_sync,
+            warning_lightningd_sync: info.warning_lightningd_sync,
         }
     }
 }
@@ -98,7 +428,7 @@ pub struct ShutdownResponse {}
 
 #[derive(Clone, Debug)]
 pub struct MakeInvoiceRequest {
-    pub amount_msat: u64,
+    pub amount_msat: Msat,
     pub description: String,
     pub label: String,
     pub expiry: Option<u64>,
@@ -115,9 +445,7 @@ impl TryFrom<MakeInvoiceRequest> for cln::InvoiceRequest {
         Ok(cln::InvoiceRequest {
             label: req.label,
             amount_msat: Some(cln::AmountOrAny {
-                value: Some(cln::amount_or_any::Value::Amount(cln::Amount {
-                    msat: req.amount_msat,
-                })),
+                value: Some(cln::amount_or_any::Value::Amount(req.amount_msat.into())),
             }),
             description: req.description,
             expiry: req.expiry,
@@ -168,12 +496,14 @@ impl From<cln::InvoiceResponse> for MakeInvoiceResponse {
 #[derive(Clone, Debug)]
 pub struct PayRequest {
     pub bolt11: String,
+    pub label: Option<String>,
 }
 
 impl From<PayRequest> for cln::PayRequest {
     fn from(req: PayRequest) -> Self {
         cln::PayRequest {
             bolt11: req.bolt11,
+            label: req.label,
             ..Default::default()
         }
     }
@@ -182,12 +512,45 @@ impl From<PayRequest> for cln::PayRequest {
 #[derive(Clone, Debug)]
 pub struct PayResponse {
     pub preimage: String,
+    pub payment_hash: String,
+    pub destination: Option<String>,
+    pub created_at: f64,
+    pub parts: u32,
+    pub amount_msat: Option<Msat>,
+    pub amount_sent_msat: Option<Msat>,
+    pub warning_partial_completion: Option<String>,
+    pub status: ListPaymentsStatus,
 }
 
 impl From<cln::PayResponse> for PayResponse {
     fn from(pay: cln::PayResponse) -> Self {
+        let status = pay.status();
         PayResponse {
             preimage: hex::encode(pay.payment_preimage),
+            payment_hash: hex::encode(pay.payment_hash),
+            destination: pay.destination.map(hex::encode),
+            created_at: pay.created_at,
+            parts: pay.parts,
+            amount_msat: pay.amount_msat.map(Msat::from),
+            amount_sent_msat: pay.amount_sent_msat.map(Msat::from),
+            warning_partial_completion: pay.warning_partial_completion,
+            status: status.into(),
+        }
+    }
+}
+
+impl From<ListPaymentsPayment> for PayResponse {
+    fn from(payment: ListPaymentsPayment) -> Self {
+        PayResponse {
+            preimage: payment.preimage.unwrap_or_default(),
+            payment_hash: payment.payment_hash,
+            destination: payment.destination,
+            created_at: payment.created_at as f64,
+            parts: payment.number_of_parts.unwrap_or_default() as u32,
+            amount_msat: payment.amount_msat,
+            amount_sent_msat: payment.amount_sent_msat,
+            warning_partial_completion: None,
+            status: ListPaymentsStatus::Complete,
         }
     }
 }
@@ -214,7 +577,7 @@ impl TryFrom<TlvEntry> for cln::TlvEntry {
 #[derive(Clone, Debug)]
 pub struct KeySendRequest {
     pub destination: String,
-    pub amount_msat: Option<u64>,
+    pub amount_msat: Option<Msat>,
     pub label: Option<String>,
     pub extra_tlvs: Option<Vec<TlvEntry>>,
 }
@@ -227,7 +590,7 @@ impl TryFrom<KeySendRequest> for cln::KeysendRequest {
             destination: hex::decode(req.destination)
                 .context("destination contains invalid hex value")
                 .map_err(SdkError::invalid_arg)?,
-            amount_msat: req.amount_msat.map(|a| cln::Amount { msat: a }),
+            amount_msat: req.amount_msat.map(cln::Amount::from),
             label: req.label,
             extratlvs: req
                 .extra_tlvs
@@ -246,12 +609,45 @@ impl TryFrom<KeySendRequest> for cln::KeysendRequest {
 #[derive(Clone, Debug)]
 pub struct KeySendResponse {
     pub payment_preimage: String,
+    pub payment_hash: String,
+    pub destination: Option<String>,
+    pub created_at: f64,
+    pub parts: u32,
+    pub amount_msat: Option<Msat>,
+    pub amount_sent_msat: Option<Msat>,
+    pub warning_partial_completion: Option<String>,
+    pub status: ListPaymentsStatus,
 }
 
 impl From<cln::KeysendResponse> for KeySendResponse {
     fn from(pay: cln::KeysendResponse) -> Self {
+        let status = pay.status();
         KeySendResponse {
             payment_preimage: hex::encode(pay.payment_preimage),
+            payment_hash: hex::encode(pay.payment_hash),
+            destination: pay.destination.map(hex::encode),
+            created_at: pay.created_at,
+            parts: pay.parts,
+            amount_msat: pay.amount_msat.map(Msat::from),
+            amount_sent_msat: pay.amount_sent_msat.map(Msat::from),
+            warning_partial_completion: pay.warning_partial_completion,
+            status: status.into(),
+        }
+    }
+}
+
+impl From<ListPaymentsPayment> for KeySendResponse {
+    fn from(payment: ListPaymentsPayment) -> Self {
+        KeySendResponse {
+            payment_preimage: payment.preimage.unwrap_or_default(),
+            payment_hash: payment.payment_hash,
+            destination: payment.destination,
+            created_at: payment.created_at as f64,
+            parts: payment.number_of_parts.unwrap_or_default() as u32,
+            amount_msat: payment.amount_msat,
+            amount_sent_msat: payment.amount_sent_msat,
+            warning_partial_completion: None,
+            status: ListPaymentsStatus::Complete,
         }
     }
 }
@@ -267,60 +663,123 @@ impl From<ListFundsRequest> for cln::ListfundsRequest {
     }
 }
 
+#[derive(Copy, Clone, Debug, PartialEq, Eq)]
+pub enum ListFundsOutputStatus {
+    Unconfirmed,
+    Confirmed,
+    Spent,
+    Immature,
+}
+
+impl From<cln::listfunds_outputs::ListfundsOutputsStatus> for ListFundsOutputStatus {
+    fn from(s: cln::listfunds_outputs::ListfundsOutputsStatus) -> Self {
+        use cln::listfunds_outputs::ListfundsOutputsStatus;
+
+        match s {
+            ListfundsOutputsStatus::Unconfirmed => ListFundsOutputStatus::Unconfirmed,
+            ListfundsOutputsStatus::Confirmed => ListFundsOutputStatus::Confirmed,
+            ListfundsOutputsStatus::Spent => ListFundsOutputStatus::Spent,
+            ListfundsOutputsStatus::Immature => ListFundsOutputStatus::Immature,
+        }
+    }
+}
+
 #[derive(Clone, Debug)]
 pub struct ListFundsOutput {
     pub txid: String,
     pub output: u32,
-    pub amount_msat: Option<u64>,
+    pub amount_msat: Option<Msat>,
     pub scriptpubkey: String,
     pub address: Option<String>,
     pub redeemscript: Option<String>,
-    pub status: i32,
+    pub status: ListFundsOutputStatus,
     pub reserved: bool,
     pub blockheight: Option<u32>,
 }
 
 impl From<cln::ListfundsOutputs> for ListFundsOutput {
     fn from(output: cln::ListfundsOutputs) -> Self {
+        let status = output.status();
         ListFundsOutput {
             txid: hex::encode(output.txid),
             output: output.output,
-            amount_msat: output.amount_msat.map(|a| a.msat),
+            amount_msat: output.amount_msat.map(Msat::from),
             scriptpubkey: hex::encode(output.scriptpubkey),
             address: output.address,
             redeemscript: output.redeemscript.map(hex::encode),
-            status: output.status,
+            status: status.into(),
             reserved: output.reserved,
             blockheight: output.blockheight,
         }
     }
 }
 
+#[derive(Copy, Clone, Debug, PartialEq, Eq)]
+pub enum ChannelState {
+    Openingd,
+    ChanneldAwaitingLockin,
+    ChanneldNormal,
+    ChanneldShuttingDown,
+    ClosingdSigexchange,
+    ClosingdComplete,
+    AwaitingUnilateral,
+    FundingSpendSeen,
+    Onchain,
+    DualopendOpenInit,
+    DualopendAwaitingLockin,
+    ChanneldAwaitingSplice,
+}
+
+impl From<cln::ChannelState> for ChannelState {
+    fn from(s: cln::ChannelState) -> Self {
+        match s {
+            cln::ChannelState::Openingd => ChannelState::Openingd,
+            cln::ChannelState::ChanneldAwaitingLockin => ChannelState::ChanneldAwaitingLockin,
+            cln::ChannelState::ChanneldNormal => ChannelState::ChanneldNormal,
+            cln::ChannelState::ChanneldShuttingDown => ChannelState::ChanneldShuttingDown,
+            cln::ChannelState::ClosingdSigexchange => ChannelState::ClosingdSigexchange,
+            cln::ChannelState::ClosingdComplete => ChannelState::ClosingdComplete,
+            cln::ChannelState::AwaitingUnilateral => ChannelState::AwaitingUnilateral,
+            cln::ChannelState::FundingSpendSeen => ChannelState::FundingSpendSeen,
+            cln::ChannelState::Onchain => ChannelState::Onchain,
+            cln::ChannelState::DualopendOpenInit => ChannelState::DualopendOpenInit,
+            cln::ChannelState::DualopendAwaitingLockin => ChannelState::DualopendAwaitingLockin,
+            cln::ChannelState::ChanneldAwaitingSplice => ChannelState::ChanneldAwaitingSplice,
+        }
+    }
+}
+
 #[derive(Clone, Debug)]
 pub struct ListFundsChannel {
     pub peer_id: String,
-    pub our_amount_msat: Option<u64>,
-    pub amount_msat: Option<u64>,
+    pub our_amount_msat: Option<Msat>,
+    pub amount_msat: Option<Msat>,
     pub funding_txid: String,
     pub funding_output: u32,
     pub connected: bool,
-    pub state: i32,
+    pub state: ChannelState,
     pub channel_id: Option<String>,
     pub short_channel_id: Option<String>,
+    // Not part of listfunds; list_funds fills them in from listpeerchannels.
+    pub our_reserve_msat: Option<Msat>,
+    pub their_reserve_msat: Option<Msat>,
 }
 
 impl From<cln::ListfundsChannels> for ListFundsChannel {
     fn from(channel: cln::ListfundsChannels) -> Self {
+        let state = channel.state();
         ListFundsChannel {
             peer_id: hex::encode(channel.peer_id),
-            our_amount_msat: channel.our_amount_msat.map(|a| a.msat),
-            amount_msat: channel.amount_msat.map(|a| a.msat),
+            our_amount_msat: channel.our_amount_msat.map(Msat::from),
+            amount_msat: channel.amount_msat.map(Msat::from),
             funding_txid: hex::encode(channel.funding_txid),
             funding_output: channel.funding_output,
             connected: channel.connected,
-            state: channel.state,
+            state: state.into(),
             channel_id: channel.channel_id.map(hex::encode),
             short_channel_id: channel.short_channel_id,
+            our_reserve_msat: None,
+            their_reserve_msat: None,
         }
     }
 }
@@ -348,6 +807,115 @@ impl From<cln::ListfundsResponse> for ListFundsResponse {
     }
 }
 
+// Channel reserves default to 1% of the channel capacity, which is assumed
+// when the actual reserve wasn't reported.
+const CHANNEL_RESERVE_PERCENT: u64 = 1;
+
+impl ListFundsChannel {
+    // Whether the channel can currently route payments.
+    pub fn is_usable(&self) -> bool {
+        self.connected && self.state == ChannelState::ChanneldNormal
+    }
+
+    fn default_reserve_msat(&self) -> Msat {
+        Msat(self.amount_msat.unwrap_or_default().0 * CHANNEL_RESERVE_PERCENT / 100)
+    }
+
+    // The reserve we have to keep in the channel.
+    pub fn reserve_msat(&self) -> Msat {
+        self.our_reserve_msat
+            .unwrap_or_else(|| self.default_reserve_msat())
+    }
+
+    // Amount we can send over the channel once our reserve is held back.
+    pub fn spendable_msat(&self) -> Msat {
+        Msat(
+            self.our_amount_msat
+                .unwrap_or_default()
+                .0
+                .saturating_sub(self.reserve_msat().0),
+        )
+    }
+
+    // Amount the peer can push to us once their reserve is held back.
+    pub fn receivable_msat(&self) -> Msat {
+        let their_amount = self
+            .amount_msat
+            .unwrap_or_default()
+            .0
+            .saturating_sub(self.our_amount_msat.unwrap_or_default().0);
+        let their_reserve = self
+            .their_reserve_msat
+            .unwrap_or_else(|| self.default_reserve_msat());
+        Msat(their_amount.saturating_sub(their_reserve.0))
+    }
+}
+
+#[derive(Clone, Debug, Default)]
+pub struct FundsSummary {
+    pub onchain_confirmed_msat: Msat,
+    pub onchain_unconfirmed_msat: Msat,
+    pub onchain_reserved_msat: Msat,
+    pub spendable_msat: Msat,
+    pub receivable_msat: Msat,
+    pub pending_channels_msat: Msat,
+    pub closing_channels_msat: Msat,
+    // Our balance in open channels that can't be used right now, because
+    // the peer is disconnected or a splice is confirming.
+    pub unavailable_channels_msat: Msat,
+}
+
+impl ListFundsResponse {
+    // Sums outputs and channels into the totals wallets usually display.
+    // Only usable channels count towards spendable and receivable; balances
+    // in channels that are unavailable, still opening or already closing are
+    // reported separately.
+    pub fn summary(&self) -> FundsSummary {
+        let mut summary = FundsSummary::default();
+        for output in &self.outputs {
+            let amount = output.amount_msat.unwrap_or_default().0;
+            let total = if output.reserved {
+                &mut summary.onchain_reserved_msat
+            } else {
+                match output.status {
+                    ListFundsOutputStatus::Confirmed => &mut summary.onchain_confirmed_msat,
+                    ListFundsOutputStatus::Unconfirmed | ListFundsOutputStatus::Immature => {
+                        &mut summary.onchain_unconfirmed_msat
+                    }
+                    ListFundsOutputStatus::Spent => continue,
+                }
+            };
+            total.0 += amount;
+        }
+        for channel in &self.channels {
+            let our_amount = channel.our_amount_msat.unwrap_or_default().0;
+            match channel.state {
+                ChannelState::ChanneldNormal | ChannelState::ChanneldAwaitingSplice => {
+                    if channel.is_usable() {
+                        summary.spendable_msat.0 += channel.spendable_msat().0;
+                        summary.receivable_msat.0 += channel.receivable_msat().0;
+                    } else {
+                        summary.unavailable_channels_msat.0 += our_amount;
+                    }
+                }
+                ChannelState::Openingd
+                | ChannelState::ChanneldAwaitingLockin
+                | ChannelState::DualopendOpenInit
+                | ChannelState::DualopendAwaitingLockin => {
+                    summary.pending_channels_msat.0 += our_amount
+                }
+                ChannelState::ChanneldShuttingDown
+                | ChannelState::ClosingdSigexchange
+                | ChannelState::ClosingdComplete
+                | ChannelState::AwaitingUnilateral
+                | ChannelState::FundingSpendSeen
+                | ChannelState::Onchain => summary.closing_channels_msat.0 += our_amount,
+            }
+        }
+        summary
+    }
+}
+
 #[derive(Clone, Debug)]
 pub struct ConnectPeerRequest {
     pub id: String,
@@ -365,15 +933,57 @@ impl From<ConnectPeerRequest> for cln::ConnectRequest {
     }
 }
 
+#[derive(Clone, Debug)]
+pub struct ConnectPeerAddress {
+    pub item_type: AddressType,
+    pub socket: Option<String>,
+    pub address: Option<String>,
+    pub port: Option<u32>,
+}
+
+impl From<cln::ConnectAddress> for ConnectPeerAddress {
+    fn from(address: cln::ConnectAddress) -> Self {
+        ConnectPeerAddress {
+            item_type: address.item_type().into(),
+            socket: address.socket,
+            address: address.address,
+            port: address.port,
+        }
+    }
+}
+
+// Whether the peer connected to us or we connected to it.
+#[derive(Copy, Clone, Debug, PartialEq, Eq)]
+pub enum ConnectDirection {
+    In,
+    Out,
+}
+
+impl From<cln::connect_response::ConnectDirection> for ConnectDirection {
+    fn from(d: cln::connect_response::ConnectDirection) -> Self {
+        match d {
+            cln::connect_response::ConnectDirection::In => ConnectDirection::In,
+            cln::connect_response::ConnectDirection::Out => ConnectDirection::Out,
+        }
+    }
+}
+
 #[derive(Clone, Debug)]
 pub struct ConnectPeerResponse {
     pub id: String,
+    pub features: String,
+    pub direction: ConnectDirection,
+    pub address: Option<ConnectPeerAddress>,
 }
 
 impl From<cln::ConnectResponse> for ConnectPeerResponse {
     fn from(response: cln::ConnectResponse) -> Self {
+        let direction = response.direction();
         ConnectPeerResponse {
             id: hex::encode(response.id),
+            features: hex::encode(response.features),
+            direction: direction.into(),
+            address: response.address.map(ConnectPeerAddress::from),
         }
     }
 }
@@ -381,7 +991,7 @@ impl From<cln::ConnectResponse> for ConnectPeerResponse {
 #[derive(Clone, Debug)]
 pub struct FundChannelRequest {
     pub id: String,
-    pub amount_msat: Option<u64>,
+    pub amount_msat: Option<Msat>,
     pub announce: Option<bool>,
     pub minconf: Option<u32>,
 }
@@ -395,7 +1005,7 @@ impl TryFrom<FundChannelRequest> for cln::FundchannelRequest {
                 .context("channel id contains invalid hex value")
                 .map_err(SdkError::invalid_arg)?,
             amount: req.amount_msat.map(|a| cln::AmountOrAll {
-                value: Some(cln::amount_or_all::Value::Amount(cln::Amount { msat: a })),
+                value: Some(cln::amount_or_all::Value::Amount(a.into())),
             }),
             announce: req.announce,
             minconf: req.minconf,
@@ -480,6 +1090,52 @@ impl From<ListInvoicesIndex> for cln::listinvoices_request::ListinvoicesIndex {
     }
 }
 
+#[derive(Copy, Clone, Debug, PartialEq, Eq)]
+pub enum ListInvoicesStatus {
+    Unpaid,
+    Paid,
+    Expired,
+}
+
+impl From<ListInvoicesStatus> for cln::listinvoices_invoices::ListinvoicesInvoicesStatus {
+    fn from(s: ListInvoicesStatus) -> Self {
+        match s {
+            ListInvoicesStatus::Unpaid => {
+                cln::listinvoices_invoices::ListinvoicesInvoicesStatus::Unpaid
+            }
+            ListInvoicesStatus::Paid => {
+                cln::listinvoices_invoices::ListinvoicesInvoicesStatus::Paid
+            }
+            ListInvoicesStatus::Expired => {
+                cln::listinvoices_invoices::ListinvoicesInvoicesStatus::Expired
+            }
+        }
+    }
+}
+
+impl From<cln::waitanyinvoice_response::WaitanyinvoiceStatus> for ListInvoicesStatus {
+    fn from(s: cln::waitanyinvoice_response::WaitanyinvoiceStatus) -> Self {
+        match s {
+            cln::waitanyinvoice_response::WaitanyinvoiceStatus::Paid => ListInvoicesStatus::Paid,
+            cln::waitanyinvoice_response::WaitanyinvoiceStatus::Expired => {
+                ListInvoicesStatus::Expired
+            }
+        }
+    }
+}
+
+impl From<cln::listinvoices_invoices::ListinvoicesInvoicesStatus> for ListInvoicesStatus {
+    fn from(s: cln::listinvoices_invoices::ListinvoicesInvoicesStatus) -> Self {
+        use cln::listinvoices_invoices::ListinvoicesInvoicesStatus;
+
+        match s {
+            ListinvoicesInvoicesStatus::Unpaid => ListInvoicesStatus::Unpaid,
+            ListinvoicesInvoicesStatus::Paid => ListInvoicesStatus::Paid,
+            ListinvoicesInvoicesStatus::Expired => ListInvoicesStatus::Expired,
+        }
+    }
+}
+
 #[derive(Clone, Debug)]
 pub struct ListInvoicesRequest {
     pub label: Option<String>,
@@ -489,6 +1145,26 @@ pub struct ListInvoicesRequest {
     pub index: Option<ListInvoicesIndex>,
     pub start: Option<u64>,
     pub limit: Option<u32>,
+    pub status: Option<ListInvoicesStatus>,
+    pub exclude_expired: Option<bool>,
+}
+
+impl ListInvoicesRequest {
+    fn is_filtered(&self) -> bool {
+        self.status.is_some() || self.exclude_expired.unwrap_or(false)
+    }
+
+    // listinvoices has no status filter; invoices are checked against it
+    // as they come back from the node.
+    pub(crate) fn matches(&self, invoice: &ListInvoicesInvoice) -> bool {
+        if self.exclude_expired.unwrap_or(false) && invoice.status == ListInvoicesStatus::Expired {
+            return false;
+        }
+        match self.status {
+            Some(status) => invoice.status == status,
+            None => true,
+        }
+    }
 }
 
 impl TryFrom<ListInvoicesRequest> for cln::ListinvoicesRequest {
@@ -535,9 +1211,9 @@ pub struct ListInvoicesInvoice {
     pub label: String,
     pub description: Option<String>,
     pub payment_hash: String,
-    pub status: i32,
+    pub status: ListInvoicesStatus,
     pub expires_at: u64,
-    pub amount_msat: Option<u64>,
+    pub amount_msat: Option<Msat>,
     pub bolt11: Option<String>,
     pub bolt12: Option<String>,
     pub local_offer_id: Option<String>,
@@ -545,7 +1221,7 @@ pub struct ListInvoicesInvoice {
     pub created_index: Option<u64>,
     pub updated_index: Option<u64>,
     pub pay_index: Option<u64>,
-    pub amount_received_msat: Option<u64>,
+    pub amount_received_msat: Option<Msat>,
     pub paid_at: Option<u64>,
     pub paid_outpoint: Option<ListInvoicesInvoicePaidOutpoint>,
     pub payment_preimage: Option<String>,
@@ -553,13 +1229,14 @@ pub struct ListInvoicesInvoice {
 
 impl From<cln::ListinvoicesInvoices> for ListInvoicesInvoice {
     fn from(invoice: cln::ListinvoicesInvoices) -> Self {
+        let status = invoice.status();
         ListInvoicesInvoice {
             label: invoice.label,
             description: invoice.description,
             payment_hash: hex::encode(invoice.payment_hash),
-            status: invoice.status,
+            status: status.into(),
             expires_at: invoice.expires_at,
-            amount_msat: invoice.amount_msat.map(|a| a.msat),
+            amount_msat: invoice.amount_msat.map(Msat::from),
             bolt11: invoice.bolt11,
             bolt12: invoice.bolt12,
             local_offer_id: invoice.local_offer_id.map(hex::encode),
@@ -567,7 +1244,7 @@ impl From<cln::ListinvoicesInvoices> for ListInvoicesInvoice {
             created_index: invoice.created_index,
             updated_index: invoice.updated_index,
             pay_index: invoice.pay_index,
-            amount_received_msat: invoice.amount_received_msat.map(|a| a.msat),
+            amount_received_msat: invoice.amount_received_msat.map(Msat::from),
             paid_at: invoice.paid_at,
             paid_outpoint: invoice
                 .paid_outpoint
@@ -594,7 +1271,7 @@ impl From<cln::ListinvoicesResponse> for ListInvoicesResponse {
     }
 }
 
-#[derive(Copy, Clone, Debug)]
+#[derive(Copy, Clone, Debug, PartialEq, Eq)]
 pub enum ListPaymentsStatus {
     Pending,
     Complete,
@@ -611,18 +1288,130 @@ impl From<ListPaymentsStatus> for cln::listpays_request::ListpaysStatus {
     }
 }
 
+impl From<cln::listpays_pays::ListpaysPaysStatus> for ListPaymentsStatus {
+    fn from(s: cln::listpays_pays::ListpaysPaysStatus) -> Self {
+        use cln::listpays_pays::ListpaysPaysStatus;
+
+        match s {
+            ListpaysPaysStatus::Pending => ListPaymentsStatus::Pending,
+            ListpaysPaysStatus::Complete => ListPaymentsStatus::Complete,
+            ListpaysPaysStatus::Failed => ListPaymentsStatus::Failed,
+        }
+    }
+}
+
+impl From<cln::pay_response::PayStatus> for ListPaymentsStatus {
+    fn from(s: cln::pay_response::PayStatus) -> Self {
+        use cln::pay_response::PayStatus;
+
+        match s {
+            PayStatus::Pending => ListPaymentsStatus::Pending,
+            PayStatus::Complete => ListPaymentsStatus::Complete,
+            PayStatus::Failed => ListPaymentsStatus::Failed,
+        }
+    }
+}
+
+impl From<cln::keysend_response::KeysendStatus> for ListPaymentsStatus {
+    fn from(s: cln::keysend_response::KeysendStatus) -> Self {
+        match s {
+            cln::keysend_response::KeysendStatus::Complete => ListPaymentsStatus::Complete,
+        }
+    }
+}
+
+impl From<cln::sendpay_response::SendpayStatus> for ListPaymentsStatus {
+    fn from(s: cln::sendpay_response::SendpayStatus) -> Self {
+        match s {
+            cln::sendpay_response::SendpayStatus::Pending => ListPaymentsStatus::Pending,
+            cln::sendpay_response::SendpayStatus::Complete => ListPaymentsStatus::Complete,
+        }
+    }
+}
+
+impl From<cln::waitsendpay_response::WaitsendpayStatus> for ListPaymentsStatus {
+    fn from(s: cln::waitsendpay_response::WaitsendpayStatus) -> Self {
+        match s {
+            cln::waitsendpay_response::WaitsendpayStatus::Complete => ListPaymentsStatus::Complete,
+        }
+    }
+}
+
+impl From<cln::listsendpays_payments::ListsendpaysPaymentsStatus> for ListPaymentsStatus {
+    fn from(s: cln::listsendpays_payments::ListsendpaysPaymentsStatus) -> Self {
+        use cln::listsendpays_payments::ListsendpaysPaymentsStatus;
+
+        match s {
+            ListsendpaysPaymentsStatus::Pending => ListPaymentsStatus::Pending,
+            ListsendpaysPaymentsStatus::Complete => ListPaymentsStatus::Complete,
+            ListsendpaysPaymentsStatus::Failed => ListPaymentsStatus::Failed,
+        }
+    }
+}
+
+impl From<ListPaymentsStatus> for cln::listsendpays_request::ListsendpaysStatus {
+    fn from(s: ListPaymentsStatus) -> Self {
+        match s {
+            ListPaymentsStatus::Pending => cln::listsendpays_request::ListsendpaysStatus::Pending,
+            ListPaymentsStatus::Complete => cln::listsendpays_request::ListsendpaysStatus::Complete,
+            ListPaymentsStatus::Failed => cln::listsendpays_request::ListsendpaysStatus::Failed,
+        }
+    }
+}
+
+#[derive(Copy, Clone, Debug)]
+pub enum SortDirection {
+    Ascending,
+    Descending,
+}
+
+#[derive(Copy, Clone, Debug)]
+pub enum ListPaymentsIndex {
+    Created,
+    Updated,
+}
+
+impl From<ListPaymentsIndex> for cln::listsendpays_request::ListsendpaysIndex {
+    fn from(i: ListPaymentsIndex) -> Self {
+        match i {
+            ListPaymentsIndex::Created => cln::listsendpays_request::ListsendpaysIndex::Created,
+            ListPaymentsIndex::Updated => cln::listsendpays_request::ListsendpaysIndex::Updated,
+        }
+    }
+}
+
 #[derive(Clone, Debug)]
 pub struct ListPaymentsRequest {
     pub bolt11: Option<String>,
     pub payment_hash: Option<String>,
     pub status: Option<ListPaymentsStatus>,
+    pub index: Option<ListPaymentsIndex>,
+    pub start: Option<u64>,
+    pub limit: Option<u32>,
+    pub created_from: Option<u64>,
+    pub created_to: Option<u64>,
+    pub sort_direction: Option<SortDirection>,
+}
+
+impl ListPaymentsRequest {
+    // listsendpays filters by the status of individual parts, so a payment
+    // that failed one part and then completed would be split. The status is
+    // checked here instead, once parts are grouped into payments, along with
+    // the creation time, which CLN cannot filter by at all.
+    pub(crate) fn matches(&self, payment: &ListPaymentsPayment) -> bool {
+        self.status.map_or(true, |status| payment.status == status)
+            && self
+                .created_from
+                .map_or(true, |from| payment.created_at >= from)
+            && self.created_to.map_or(true, |to| payment.created_at <= to)
+    }
 }
 
-impl TryFrom<ListPaymentsRequest> for cln::ListpaysRequest {
+impl TryFrom<ListPaymentsRequest> for cln::ListsendpaysRequest {
     type Error = SdkError;
 
     fn try_from(req: ListPaymentsRequest) -> Result<Self> {
-        Ok(cln::ListpaysRequest {
+        Ok(cln::ListsendpaysRequest {
             bolt11: req.bolt11,
             payment_hash: req
                 .payment_hash
@@ -630,10 +1419,14 @@ impl TryFrom<ListPaymentsRequest> for cln::ListpaysRequest {
                 .transpose()
                 .context("payment hash contains invalid hex value")
                 .map_err(SdkError::invalid_arg)?,
-            status: req
-                .status
-                .map(cln::listpays_request::ListpaysStatus::from)
-                .map(|s| s as i32),
+            // Matched per payment instead, see ListPaymentsRequest::matches.
+            status: None,
+            index: req
+                .index
+                .map(cln::listsendpays_request::ListsendpaysIndex::from)
+                .map(|i| i as i32),
+            start: req.start,
+            limit: req.limit,
         })
     }
 }
@@ -641,7 +1434,7 @@ impl TryFrom<ListPaymentsRequest> for cln::ListpaysRequest {
 #[derive(Clone, Debug)]
 pub struct ListPaymentsPayment {
     pub payment_hash: String,
-    pub status: i32,
+    pub status: ListPaymentsStatus,
     pub destination: Option<String>,
     pub created_at: u64,
     pub completed_at: Option<u64>,
@@ -649,18 +1442,21 @@ pub struct ListPaymentsPayment {
     pub bolt11: Option<String>,
     pub description: Option<String>,
     pub bolt12: Option<String>,
-    pub amount_msat: Option<u64>,
-    pub amount_sent_msat: Option<u64>,
+    pub amount_msat: Option<Msat>,
+    pub amount_sent_msat: Option<Msat>,
     pub preimage: Option<String>,
     pub number_of_parts: Option<u64>,
     pub erroronion: Option<String>,
+    pub created_index: Option<u64>,
+    pub updated_index: Option<u64>,
 }
 
 impl From<cln::ListpaysPays> for ListPaymentsPayment {
     fn from(payment: cln::ListpaysPays) -> Self {
+        let status = payment.status();
         ListPaymentsPayment {
             payment_hash: hex::encode(payment.payment_hash),
-            status: payment.status,
+            status: status.into(),
             destination: payment.destination.map(hex::encode),
             created_at: payment.created_at,
             completed_at: payment.completed_at,
@@ -668,11 +1464,69 @@ impl From<cln::ListpaysPays> for ListPaymentsPayment {
             bolt11: payment.bolt11,
             description: payment.description,
             bolt12: payment.bolt12,
-            amount_msat: payment.amount_msat.map(|a| a.msat),
-            amount_sent_msat: payment.amount_sent_msat.map(|a| a.msat),
+            amount_msat: payment.amount_msat.map(Msat::from),
+            amount_sent_msat: payment.amount_sent_msat.map(Msat::from),
             preimage: payment.preimage.map(hex::encode),
             number_of_parts: payment.number_of_parts,
             erroronion: payment.erroronion.map(hex::encode),
+            created_index: None,
+            updated_index: None,
+        }
+    }
+}
+
+impl From<Vec<cln::ListsendpaysPayments>> for ListPaymentsPayment {
+    fn from(parts: Vec<cln::ListsendpaysPayments>) -> Self {
+        use cln::listsendpays_payments::ListsendpaysPaymentsStatus;
+
+        let completed: Vec<&cln::ListsendpaysPayments> = parts
+            .iter()
+            .filter(|p| p.status == ListsendpaysPaymentsStatus::Complete as i32)
+            .collect();
+        let status = if !completed.is_empty() {
+            ListPaymentsStatus::Complete
+        } else if parts
+            .iter()
+            .any(|p| p.status == ListsendpaysPaymentsStatus::Pending as i32)
+        {
+            ListPaymentsStatus::Pending
+        } else {
+            ListPaymentsStatus::Failed
+        };
+        // Like listpays, amounts are only reported for completed payments.
+        let sum_completed = |amount: fn(&cln::ListsendpaysPayments) -> Option<u64>| {
+            if completed.is_empty() {
+                None
+            } else {
+                Some(completed.iter().filter_map(|p| amount(p)).sum())
+            }
+        };
+
+        let first = &parts[0];
+        ListPaymentsPayment {
+            payment_hash: hex::encode(&first.payment_hash),
+            status,
+            destination: first.destination.as_ref().map(hex::encode),
+            created_at: parts.iter().map(|p| p.created_at).min().unwrap_or_default(),
+            completed_at: parts.iter().filter_map(|p| p.completed_at).max(),
+            label: first.label.clone(),
+            bolt11: first.bolt11.clone(),
+            description: first.description.clone(),
+            bolt12: first.bolt12.clone(),
+            amount_msat: sum_completed(|p| p.amount_msat.as_ref().map(|a| a.msat)).map(Msat),
+            amount_sent_msat: sum_completed(|p| p.amount_sent_msat.as_ref().map(|a| a.msat))
+                .map(Msat),
+            preimage: parts
+                .iter()
+                .find_map(|p| p.payment_preimage.as_ref())
+                .map(hex::encode),
+            number_of_parts: sum_completed(|_| Some(1)),
+            erroronion: parts
+                .iter()
+                .find_map(|p| p.erroronion.as_ref())
+                .map(hex::encode),
+            created_index: parts.iter().filter_map(|p| p.created_index).min(),
+            updated_index: parts.iter().filter_map(|p| p.updated_index).max(),
         }
     }
 }
@@ -694,6 +1548,30 @@ impl From<cln::ListpaysResponse> for ListPaymentsResponse {
     }
 }
 
+impl From<cln::ListsendpaysResponse> for ListPaymentsResponse {
+    fn from(response: cln::ListsendpaysResponse) -> Self {
+        // listsendpays returns the individual payment parts, so group them
+        // by payment hash and group id the same way listpays does, keeping
+        // the order in which payments were first seen.
+        let mut groups: Vec<Vec<cln::ListsendpaysPayments>> = Vec::new();
+        let mut group_index: HashMap<(Vec<u8>, u64), usize> = HashMap::new();
+        for part in response.payments {
+            let key = (part.payment_hash.clone(), part.groupid);
+            match group_index.get(&key) {
+                Some(&i) => groups[i].push(part),
+                None => {
+                    group_index.insert(key, groups.len());
+                    groups.push(vec![part]);
+                }
+            }
+        }
+
+        ListPaymentsResponse {
+            payments: groups.into_iter().map(ListPaymentsPayment::from).collect(),
+        }
+    }
+}
+
 #[derive(Clone, Debug)]
 pub struct SignMessageRequest {
     pub message: String,
@@ -817,136 +1695,1390 @@ impl From<cln::CloseResponse> for CloseResponse {
     }
 }
 
-pub struct GreenlightAlbyClient {
-    node: gl_client::node::ClnClient,
-    shutdown: Sender<()>,
-    signer_handle: JoinHandle<()>,
+#[derive(Copy, Clone, Debug)]
+pub enum GetLogLevel {
+    Broken,
+    Unusual,
+    Info,
+    Debug,
+    Io,
+}
+
+impl From<GetLogLevel> for cln::getlog_request::GetlogLevel {
+    fn from(l: GetLogLevel) -> Self {
+        match l {
+            GetLogLevel::Broken => cln::getlog_request::GetlogLevel::Broken,
+            GetLogLevel::Unusual => cln::getlog_request::GetlogLevel::Unusual,
+            GetLogLevel::Info => cln::getlog_request::GetlogLevel::Info,
+            GetLogLevel::Debug => cln::getlog_request::GetlogLevel::Debug,
+            GetLogLevel::Io => cln::getlog_request::GetlogLevel::Io,
+        }
+    }
 }
 
-pub async fn recover(mnemonic: String) -> Result<GreenlightCredentials> {
-    let mnemonic = Mnemonic::from_str(&mnemonic)
+#[derive(Clone, Debug)]
+pub struct GetLogRequest {
+    pub level: Option<GetLogLevel>,
+}
+
+impl From<GetLogRequest> for cln::GetlogRequest {
+    fn from(req: GetLogRequest) -> Self {
+        cln::GetlogRequest {
+            level: req
+                .level
+                .map(cln::getlog_request::GetlogLevel::from)
+                .map(|l| l as i32),
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct GetLogEntry {
+    pub item_type: i32,
+    pub num_skipped: Option<u32>,
+    pub time: Option<String>,
+    pub source: Option<String>,
+    pub log: Option<String>,
+    pub node_id: Option<String>,
+    pub data: Option<String>,
+}
+
+impl From<cln::GetlogLog> for GetLogEntry {
+    fn from(entry: cln::GetlogLog) -> Self {
+        GetLogEntry {
+            item_type: entry.item_type,
+            num_skipped: entry.num_skipped,
+            time: entry.time,
+            source: entry.source,
+            log: entry.log,
+            node_id: entry.node_id.map(hex::encode),
+            data: entry.data.map(hex::encode),
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct GetLogResponse {
+    pub created_at: String,
+    pub bytes_used: u32,
+    pub bytes_max: u32,
+    pub log: Vec<GetLogEntry>,
+}
+
+impl From<cln::GetlogResponse> for GetLogResponse {
+    fn from(response: cln::GetlogResponse) -> Self {
+        GetLogResponse {
+            created_at: response.created_at,
+            bytes_used: response.bytes_used,
+            bytes_max: response.bytes_max,
+            log: response.log.into_iter().map(GetLogEntry::from).collect(),
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct ListConfigsRequest {
+    pub config: Option<String>,
+}
+
+impl From<ListConfigsRequest> for cln::ListconfigsRequest {
+    fn from(req: ListConfigsRequest) -> Self {
+        cln::ListconfigsRequest { config: req.config }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct ListConfigsResponse {
+    pub network: Option<String>,
+    pub alias: Option<String>,
+    pub fee_base: Option<u32>,
+    pub fee_per_satoshi: Option<u32>,
+    pub min_capacity_sat: Option<u64>,
+    pub cltv_delta: Option<u32>,
+    pub cltv_final: Option<u32>,
+    pub funding_confirms: Option<u32>,
+    pub max_concurrent_htlcs: Option<u32>,
+    pub max_locktime_blocks: Option<u32>,
+    pub htlc_minimum_msat: Option<Msat>,
+    pub htlc_maximum_msat: Option<Msat>,
+}
+
+impl From<cln::ListconfigsResponse> for ListConfigsResponse {
+    fn from(response: cln::ListconfigsResponse) -> Self {
+        let configs = response.configs.unwrap_or_default();
+        ListConfigsResponse {
+            network: configs.network.map(|c| c.value_str),
+            alias: configs.alias.map(|c| c.value_str),
+            fee_base: configs.fee_base.map(|c| c.value_int),
+            fee_per_satoshi: configs.fee_per_satoshi.map(|c| c.value_int),
+            min_capacity_sat: configs.min_capacity_sat.map(|c| c.value_int),
+            cltv_delta: configs.cltv_delta.map(|c| c.value_int),
+            cltv_final: configs.cltv_final.map(|c| c.value_int),
+            funding_confirms: configs.funding_confirms.map(|c| c.value_int),
+            max_concurrent_htlcs: configs.max_concurrent_htlcs.map(|c| c.value_int),
+            max_locktime_blocks: configs.max_locktime_blocks.map(|c| c.value_int),
+            htlc_minimum_msat: configs
+                .htlc_minimum_msat
+                .and_then(|c| c.value_msat)
+                .map(Msat::from),
+            htlc_maximum_msat: configs
+                .htlc_maximum_msat
+                .and_then(|c| c.value_msat)
+                .map(Msat::from),
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct SetConfigRequest {
+    pub config: String,
+    pub val: Option<String>,
+}
+
+impl From<SetConfigRequest> for cln::SetconfigRequest {
+    fn from(req: SetConfigRequest) -> Self {
+        cln::SetconfigRequest {
+            config: req.config,
+            val: req.val,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct SetConfigResponse {
+    pub config: String,
+    pub source: String,
+    pub plugin: Option<String>,
+    pub dynamic: bool,
+    pub set: Option<bool>,
+    pub value_str: Option<String>,
+    pub value_msat: Option<Msat>,
+    pub value_int: Option<i64>,
+    pub value_bool: Option<bool>,
+}
+
+impl From<cln::SetconfigResponse> for SetConfigResponse {
+    fn from(response: cln::SetconfigResponse) -> Self {
+        let config = response.config.unwrap_or_default();
+        SetConfigResponse {
+            config: config.config,
+            source: config.source,
+            plugin: config.plugin,
+            dynamic: config.dynamic,
+            set: config.set,
+            value_str: config.value_str,
+            value_msat: config.value_msat.map(Msat::from),
+            value_int: config.value_int,
+            value_bool: config.value_bool,
+        }
+    }
+}
+
+#[derive(Copy, Clone, Debug, PartialEq, Eq)]
+pub enum ForwardStatus {
+    Offered,
+    Settled,
+    LocalFailed,
+    Failed,
+}
+
+impl From<cln::listforwards_forwards::ListforwardsForwardsStatus> for ForwardStatus {
+    fn from(s: cln::listforwards_forwards::ListforwardsForwardsStatus) -> Self {
+        use cln::listforwards_forwards::ListforwardsForwardsStatus;
+
+        match s {
+            ListforwardsForwardsStatus::Offered => ForwardStatus::Offered,
+            ListforwardsForwardsStatus::Settled => ForwardStatus::Settled,
+            ListforwardsForwardsStatus::LocalFailed => ForwardStatus::LocalFailed,
+            ListforwardsForwardsStatus::Failed => ForwardStatus::Failed,
+        }
+    }
+}
+
+// Onion format of a forwarded HTLC.
+#[derive(Copy, Clone, Debug, PartialEq, Eq)]
+pub enum ForwardStyle {
+    Legacy,
+    Tlv,
+}
+
+impl From<cln::listforwards_forwards::ListforwardsForwardsStyle> for ForwardStyle {
+    fn from(s: cln::listforwards_forwards::ListforwardsForwardsStyle) -> Self {
+        use cln::listforwards_forwards::ListforwardsForwardsStyle;
+
+        match s {
+            ListforwardsForwardsStyle::Legacy => ForwardStyle::Legacy,
+            ListforwardsForwardsStyle::Tlv => ForwardStyle::Tlv,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct Forward {
+    pub in_channel: String,
+    pub in_htlc_id: Option<u64>,
+    pub in_msat: Option<Msat>,
+    pub status: ForwardStatus,
+    pub received_time: f64,
+    pub out_channel: Option<String>,
+    pub out_htlc_id: Option<u64>,
+    pub out_msat: Option<Msat>,
+    pub fee_msat: Option<Msat>,
+    pub style: Option<ForwardStyle>,
+    pub resolved_time: Option<f64>,
+    pub failcode: Option<u32>,
+    pub failreason: Option<String>,
+    pub created_index: Option<u64>,
+    pub updated_index: Option<u64>,
+}
+
+impl From<cln::ListforwardsForwards> for Forward {
+    fn from(forward: cln::ListforwardsForwards) -> Self {
+        let style = forward.style.map(|_| forward.style());
+        let status = forward.status();
+        Forward {
+            in_channel: forward.in_channel,
+            in_htlc_id: forward.in_htlc_id,
+            in_msat: forward.in_msat.map(Msat::from),
+            status: status.into(),
+            received_time: forward.received_time,
+            out_channel: forward.out_channel,
+            out_htlc_id: forward.out_htlc_id,
+            out_msat: forward.out_msat.map(Msat::from),
+            fee_msat: forward.fee_msat.map(Msat::from),
+            style: style.map(ForwardStyle::from),
+            resolved_time: forward.resolved_time,
+            failcode: forward.failcode,
+            failreason: forward.failreason,
+            created_index: forward.created_index,
+            updated_index: forward.updated_index,
+        }
+    }
+}
+
+#[derive(Copy, Clone, Debug)]
+pub enum WaitSubsystem {
+    Invoices,
+    Forwards,
+    Sendpays,
+}
+
+impl From<WaitSubsystem> for cln::wait_request::WaitSubsystem {
+    fn from(s: WaitSubsystem) -> Self {
+        match s {
+            WaitSubsystem::Invoices => cln::wait_request::WaitSubsystem::Invoices,
+            WaitSubsystem::Forwards => cln::wait_request::WaitSubsystem::Forwards,
+            WaitSubsystem::Sendpays => cln::wait_request::WaitSubsystem::Sendpays,
+        }
+    }
+}
+
+#[derive(Copy, Clone, Debug)]
+pub enum WaitIndexname {
+    Created,
+    Updated,
+    Deleted,
+}
+
+impl From<WaitIndexname> for cln::wait_request::WaitIndexname {
+    fn from(i: WaitIndexname) -> Self {
+        match i {
+            WaitIndexname::Created => cln::wait_request::WaitIndexname::Created,
+            WaitIndexname::Updated => cln::wait_request::WaitIndexname::Updated,
+            WaitIndexname::Deleted => cln::wait_request::WaitIndexname::Deleted,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct WaitRequest {
+    pub subsystem: WaitSubsystem,
+    pub indexname: WaitIndexname,
+    pub nextvalue: u64,
+}
+
+impl From<WaitRequest> for cln::WaitRequest {
+    fn from(req: WaitRequest) -> Self {
+        cln::WaitRequest {
+            subsystem: cln::wait_request::WaitSubsystem::from(req.subsystem) as i32,
+            indexname: cln::wait_request::WaitIndexname::from(req.indexname) as i32,
+            nextvalue: req.nextvalue,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct WaitDetails {
+    pub status: Option<i32>,
+    pub label: Option<String>,
+    pub description: Option<String>,
+    pub bolt11: Option<String>,
+    pub bolt12: Option<String>,
+    pub partid: Option<u64>,
+    pub groupid: Option<u64>,
+    pub payment_hash: Option<String>,
+    pub in_channel: Option<String>,
+    pub in_htlc_id: Option<u64>,
+    pub in_msat: Option<Msat>,
+    pub out_channel: Option<String>,
+}
+
+impl From<cln::WaitDetails> for WaitDetails {
+    fn from(details: cln::WaitDetails) -> Self {
+        WaitDetails {
+            status: details.status,
+            label: details.label,
+            description: details.description,
+            bolt11: details.bolt11,
+            bolt12: details.bolt12,
+            partid: details.partid,
+            groupid: details.groupid,
+            payment_hash: details.payment_hash.map(hex::encode),
+            in_channel: details.in_channel,
+            in_htlc_id: details.in_htlc_id,
+            in_msat: details.in_msat.map(Msat::from),
+            out_channel: details.out_channel,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct WaitResponse {
+    pub subsystem: i32,
+    pub created: Option<u64>,
+    pub updated: Option<u64>,
+    pub deleted: Option<u64>,
+    pub details: Option<WaitDetails>,
+}
+
+impl From<cln::WaitResponse> for WaitResponse {
+    fn from(response: cln::WaitResponse) -> Self {
+        WaitResponse {
+            subsystem: response.subsystem,
+            created: response.created,
+            updated: response.updated,
+            deleted: response.deleted,
+            details: response.details.map(WaitDetails::from),
+        }
+    }
+}
+
+#[derive(Copy, Clone, Debug)]
+pub enum AutoCleanSubsystem {
+    SucceededForwards,
+    FailedForwards,
+    SucceededPays,
+    FailedPays,
+    PaidInvoices,
+    ExpiredInvoices,
+}
+
+impl From<AutoCleanSubsystem> for cln::AutocleanSubsystem {
+    fn from(s: AutoCleanSubsystem) -> Self {
+        match s {
+            AutoCleanSubsystem::SucceededForwards => cln::AutocleanSubsystem::Succeededforwards,
+            AutoCleanSubsystem::FailedForwards => cln::AutocleanSubsystem::Failedforwards,
+            AutoCleanSubsystem::SucceededPays => cln::AutocleanSubsystem::Succeededpays,
+            AutoCleanSubsystem::FailedPays => cln::AutocleanSubsystem::Failedpays,
+            AutoCleanSubsystem::PaidInvoices => cln::AutocleanSubsystem::Paidinvoices,
+            AutoCleanSubsystem::ExpiredInvoices => cln::AutocleanSubsystem::Expiredinvoices,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct AutoCleanOnceRequest {
+    pub subsystem: AutoCleanSubsystem,
+    pub age: u64,
+}
+
+impl From<AutoCleanOnceRequest> for cln::AutocleanonceRequest {
+    fn from(req: AutoCleanOnceRequest) -> Self {
+        cln::AutocleanonceRequest {
+            subsystem: cln::AutocleanSubsystem::from(req.subsystem) as i32,
+            age: req.age,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct AutoCleanOnceResult {
+    pub cleaned: u64,
+    pub uncleaned: u64,
+}
+
+#[derive(Clone, Debug)]
+pub struct AutoCleanOnceResponse {
+    pub succeeded_forwards: Option<AutoCleanOnceResult>,
+    pub failed_forwards: Option<AutoCleanOnceResult>,
+    pub succeeded_pays: Option<AutoCleanOnceResult>,
+    pub failed_pays: Option<AutoCleanOnceResult>,
+    pub paid_invoices: Option<AutoCleanOnceResult>,
+    pub expired_invoices: Option<AutoCleanOnceResult>,
+}
+
+impl From<cln::AutocleanonceResponse> for AutoCleanOnceResponse {
+    fn from(response: cln::AutocleanonceResponse) -> Self {
+        let autoclean = response.autoclean.unwrap_or_default();
+        AutoCleanOnceResponse {
+            succeeded_forwards: autoclean.succeededforwards.map(|r| AutoCleanOnceResult {
+                cleaned: r.cleaned,
+                uncleaned: r.uncleaned,
+            }),
+            failed_forwards: autoclean.failedforwards.map(|r| AutoCleanOnceResult {
+                cleaned: r.cleaned,
+                uncleaned: r.uncleaned,
+            }),
+            succeeded_pays: autoclean.succeededpays.map(|r| AutoCleanOnceResult {
+                cleaned: r.cleaned,
+                uncleaned: r.uncleaned,
+            }),
+            failed_pays: autoclean.failedpays.map(|r| AutoCleanOnceResult {
+                cleaned: r.cleaned,
+                uncleaned: r.uncleaned,
+            }),
+            paid_invoices: autoclean.paidinvoices.map(|r| AutoCleanOnceResult {
+                cleaned: r.cleaned,
+                uncleaned: r.uncleaned,
+            }),
+            expired_invoices: autoclean.expiredinvoices.map(|r| AutoCleanOnceResult {
+                cleaned: r.cleaned,
+                uncleaned: r.uncleaned,
+            }),
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct AutoCleanStatusRequest {
+    pub subsystem: Option<AutoCleanSubsystem>,
+}
+
+impl From<AutoCleanStatusRequest> for cln::AutocleanstatusRequest {
+    fn from(req: AutoCleanStatusRequest) -> Self {
+        cln::AutocleanstatusRequest {
+            subsystem: req
+                .subsystem
+                .map(cln::AutocleanSubsystem::from)
+                .map(|s| s as i32),
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct AutoCleanStatus {
+    pub enabled: bool,
+    pub cleaned: u64,
+    pub age: Option<u64>,
+}
+
+#[derive(Clone, Debug)]
+pub struct AutoCleanStatusResponse {
+    pub succeeded_forwards: Option<AutoCleanStatus>,
+    pub failed_forwards: Option<AutoCleanStatus>,
+    pub succeeded_pays: Option<AutoCleanStatus>,
+    pub failed_pays: Option<AutoCleanStatus>,
+    pub paid_invoices: Option<AutoCleanStatus>,
+    pub expired_invoices: Option<AutoCleanStatus>,
+}
+
+impl From<cln::AutocleanstatusResponse> for AutoCleanStatusResponse {
+    fn from(response: cln::AutocleanstatusResponse) -> Self {
+        let autoclean = response.autoclean.unwrap_or_default();
+        AutoCleanStatusResponse {
+            succeeded_forwards: autoclean.succeededforwards.map(|s| AutoCleanStatus {
+                enabled: s.enabled,
+                cleaned: s.cleaned,
+                age: s.age,
+            }),
+            failed_forwards: autoclean.failedforwards.map(|s| AutoCleanStatus {
+                enabled: s.enabled,
+                cleaned: s.cleaned,
+                age: s.age,
+            }),
+            succeeded_pays: autoclean.succeededpays.map(|s| AutoCleanStatus {
+                enabled: s.enabled,
+                cleaned: s.cleaned,
+                age: s.age,
+            }),
+            failed_pays: autoclean.failedpays.map(|s| AutoCleanStatus {
+                enabled: s.enabled,
+                cleaned: s.cleaned,
+                age: s.age,
+            }),
+            paid_invoices: autoclean.paidinvoices.map(|s| AutoCleanStatus {
+                enabled: s.enabled,
+                cleaned: s.cleaned,
+                age: s.age,
+            }),
+            expired_invoices: autoclean.expiredinvoices.map(|s| AutoCleanStatus {
+                enabled: s.enabled,
+                cleaned: s.cleaned,
+                age: s.age,
+            }),
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct WaitAnyInvoiceRequest {
+    pub lastpay_index: Option<u64>,
+    pub timeout: Option<u64>,
+}
+
+impl From<WaitAnyInvoiceRequest> for cln::WaitanyinvoiceRequest {
+    fn from(req: WaitAnyInvoiceRequest) -> Self {
+        cln::WaitanyinvoiceRequest {
+            lastpay_index: req.lastpay_index,
+            timeout: req.timeout,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct WaitAnyInvoiceResponse {
+    pub label: String,
+    pub description: String,
+    pub payment_hash: String,
+    pub status: ListInvoicesStatus,
+    pub expires_at: u64,
+    pub amount_msat: Option<Msat>,
+    pub bolt11: Option<String>,
+    pub bolt12: Option<String>,
+    pub pay_index: Option<u64>,
+    pub amount_received_msat: Option<Msat>,
+    pub paid_at: Option<u64>,
+    pub payment_preimage: Option<String>,
+    pub created_index: Option<u64>,
+    pub updated_index: Option<u64>,
+}
+
+impl From<cln::WaitanyinvoiceResponse> for WaitAnyInvoiceResponse {
+    fn from(invoice: cln::WaitanyinvoiceResponse) -> Self {
+        let status = invoice.status();
+        WaitAnyInvoiceResponse {
+            label: invoice.label,
+            description: invoice.description,
+            payment_hash: hex::encode(invoice.payment_hash),
+            status: status.into(),
+            expires_at: invoice.expires_at,
+            amount_msat: invoice.amount_msat.map(Msat::from),
+            bolt11: invoice.bolt11,
+            bolt12: invoice.bolt12,
+            pay_index: invoice.pay_index,
+            amount_received_msat: invoice.amount_received_msat.map(Msat::from),
+            paid_at: invoice.paid_at,
+            payment_preimage: invoice.payment_preimage.map(hex::encode),
+            created_index: invoice.created_index,
+            updated_index: invoice.updated_index,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct PreApproveInvoiceRequest {
+    pub bolt11: String,
+}
+
+impl From<PreApproveInvoiceRequest> for cln::PreapproveinvoiceRequest {
+    fn from(req: PreApproveInvoiceRequest) -> Self {
+        cln::PreapproveinvoiceRequest {
+            bolt11: Some(req.bolt11),
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct PreApproveInvoiceResponse {}
+
+impl From<cln::PreapproveinvoiceResponse> for PreApproveInvoiceResponse {
+    fn from(_: cln::PreapproveinvoiceResponse) -> Self {
+        PreApproveInvoiceResponse {}
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct PreApproveKeysendRequest {
+    pub destination: String,
+    pub payment_hash: String,
+    pub amount_msat: Msat,
+}
+
+impl TryFrom<PreApproveKeysendRequest> for cln::PreapprovekeysendRequest {
+    type Error = SdkError;
+
+    fn try_from(req: PreApproveKeysendRequest) -> Result<Self> {
+        Ok(cln::PreapprovekeysendRequest {
+            destination: Some(
+                hex::decode(req.destination)
+                    .context("destination contains invalid hex value")
+                    .map_err(SdkError::invalid_arg)?,
+            ),
+            payment_hash: Some(
+                hex::decode(req.payment_hash)
+                    .context("payment hash contains invalid hex value")
+                    .map_err(SdkError::invalid_arg)?,
+            ),
+            amount_msat: Some(req.amount_msat.into()),
+        })
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct PreApproveKeysendResponse {}
+
+impl From<cln::PreapprovekeysendResponse> for PreApproveKeysendResponse {
+    fn from(_: cln::PreapprovekeysendResponse) -> Self {
+        PreApproveKeysendResponse {}
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct TrampolinePayRequest {
+    pub bolt11: String,
+    pub trampoline_node_id: String,
+    pub amount_msat: Option<Msat>,
+    pub label: Option<String>,
+    pub maxfeepercent: Option<f32>,
+    pub maxdelay: Option<u32>,
+    pub description: Option<String>,
+}
+
+impl TryFrom<TrampolinePayRequest> for pb::TrampolinePayRequest {
+    type Error = SdkError;
+
+    fn try_from(req: TrampolinePayRequest) -> Result<Self> {
+        Ok(pb::TrampolinePayRequest {
+            bolt11: req.bolt11,
+            trampoline_node_id: hex::decode(req.trampoline_node_id)
+                .context("trampoline node id contains invalid hex value")
+                .map_err(SdkError::invalid_arg)?,
+            amount_msat: req.amount_msat.map_or(0, |a| a.0),
+            label: req.label.unwrap_or_default(),
+            maxfeepercent: req.maxfeepercent.unwrap_or_default(),
+            maxdelay: req.maxdelay.unwrap_or_default(),
+            description: req.description.unwrap_or_default(),
+        })
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct TrampolinePayResponse {
+    pub payment_preimage: String,
+    pub payment_hash: String,
+    pub created_at: f64,
+    pub parts: u32,
+    pub amount_msat: Msat,
+    pub amount_sent_msat: Msat,
+    pub destination: String,
+}
+
+impl From<pb::TrampolinePayResponse> for TrampolinePayResponse {
+    fn from(pay: pb::TrampolinePayResponse) -> Self {
+        TrampolinePayResponse {
+            payment_preimage: hex::encode(pay.payment_preimage),
+            payment_hash: hex::encode(pay.payment_hash),
+            created_at: pay.created_at,
+            parts: pay.parts,
+            amount_msat: Msat(pay.amount_msat),
+            amount_sent_msat: Msat(pay.amount_sent_msat),
+            destination: hex::encode(pay.destination),
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct RouteHop {
+    pub id: String,
+    pub channel: String,
+    pub amount_msat: Msat,
+    pub delay: u32,
+}
+
+impl From<cln::GetrouteRoute> for RouteHop {
+    fn from(hop: cln::GetrouteRoute) -> Self {
+        RouteHop {
+            id: hex::encode(hop.id),
+            channel: hop.channel,
+            amount_msat: hop.amount_msat.map(Msat::from).unwrap_or_default(),
+            delay: hop.delay,
+        }
+    }
+}
+
+impl TryFrom<RouteHop> for cln::SendpayRoute {
+    type Error = SdkError;
+
+    fn try_from(hop: RouteHop) -> Result<Self> {
+        Ok(cln::SendpayRoute {
+            id: hex::decode(hop.id)
+                .context("route hop id contains invalid hex value")
+                .map_err(SdkError::invalid_arg)?,
+            channel: hop.channel,
+            amount_msat: Some(hop.amount_msat.into()),
+            delay: hop.delay,
+        })
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct GetRouteRequest {
+    pub id: String,
+    pub amount_msat: Msat,
+    pub riskfactor: u64,
+    pub cltv: Option<u32>,
+    pub fromid: Option<String>,
+    pub fuzzpercent: Option<u32>,
+    pub exclude: Option<Vec<String>>,
+    pub maxhops: Option<u32>,
+}
+
+impl TryFrom<GetRouteRequest> for cln::GetrouteRequest {
+    type Error = SdkError;
+
+    fn try_from(req: GetRouteRequest) -> Result<Self> {
+        Ok(cln::GetrouteRequest {
+            id: hex::decode(req.id)
+                .context("node id contains invalid hex value")
+                .map_err(SdkError::invalid_arg)?,
+            amount_msat: Some(req.amount_msat.into()),
+            riskfactor: req.riskfactor,
+            cltv: req.cltv,
+            fromid: req
+                .fromid
+                .map(hex::decode)
+                .transpose()
+                .context("from id contains invalid hex value")
+                .map_err(SdkError::invalid_arg)?,
+            fuzzpercent: req.fuzzpercent,
+            exclude: req.exclude.unwrap_or(Vec::new()),
+            maxhops: req.maxhops,
+        })
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct GetRouteResponse {
+    pub route: Vec<RouteHop>,
+}
+
+impl From<cln::GetrouteResponse> for GetRouteResponse {
+    fn from(response: cln::GetrouteResponse) -> Self {
+        GetRouteResponse {
+            route: response.route.into_iter().map(RouteHop::from).collect(),
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct SendPayRequest {
+    pub route: Vec<RouteHop>,
+    pub payment_hash: String,
+    pub label: Option<String>,
+    pub amount_msat: Option<Msat>,
+    pub bolt11: Option<String>,
+    pub payment_secret: Option<String>,
+    pub partid: Option<u64>,
+    pub groupid: Option<u64>,
+    pub description: Option<String>,
+}
+
+impl TryFrom<SendPayRequest> for cln::SendpayRequest {
+    type Error = SdkError;
+
+    fn try_from(req: SendPayRequest) -> Result<Self> {
+        Ok(cln::SendpayRequest {
+            route: req
+                .route
+                .into_iter()
+                .map(cln::SendpayRoute::try_from)
+                .collect::<Result<_>>()?,
+            payment_hash: hex::decode(req.payment_hash)
+                .context("payment hash contains invalid hex value")
+                .map_err(SdkError::invalid_arg)?,
+            label: req.label,
+            amount_msat: req.amount_msat.map(cln::Amount::from),
+            bolt11: req.bolt11,
+            payment_secret: req
+                .payment_secret
+                .map(hex::decode)
+                .transpose()
+                .context("payment secret contains invalid hex value")
+                .map_err(SdkError::invalid_arg)?,
+            partid: req.partid,
+            groupid: req.groupid,
+            description: req.description,
+            ..Default::default()
+        })
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct SendPayPart {
+    pub id: u64,
+    pub groupid: Option<u64>,
+    pub partid: Option<u64>,
+    pub payment_hash: String,
+    pub status: ListPaymentsStatus,
+    pub amount_msat: Option<Msat>,
+    pub amount_sent_msat: Option<Msat>,
+    pub destination: Option<String>,
+    pub created_at: u64,
+    pub payment_preimage: Option<String>,
+    pub created_index: Option<u64>,
+    pub updated_index: Option<u64>,
+}
+
+impl From<cln::SendpayResponse> for SendPayPart {
+    fn from(part: cln::SendpayResponse) -> Self {
+        let status = part.status();
+        SendPayPart {
+            id: part.id,
+            groupid: part.groupid,
+            partid: part.partid,
+            payment_hash: hex::encode(part.payment_hash),
+            status: status.into(),
+            amount_msat: part.amount_msat.map(Msat::from),
+            amount_sent_msat: part.amount_sent_msat.map(Msat::from),
+            destination: part.destination.map(hex::encode),
+            created_at: part.created_at,
+            payment_preimage: part.payment_preimage.map(hex::encode),
+            created_index: None,
+            updated_index: None,
+        }
+    }
+}
+
+impl From<cln::WaitsendpayResponse> for SendPayPart {
+    fn from(part: cln::WaitsendpayResponse) -> Self {
+        let status = part.status();
+        SendPayPart {
+            id: part.id,
+            groupid: part.groupid,
+            partid: part.partid,
+            payment_hash: hex::encode(part.payment_hash),
+            status: status.into(),
+            amount_msat: part.amount_msat.map(Msat::from),
+            amount_sent_msat: part.amount_sent_msat.map(Msat::from),
+            destination: part.destination.map(hex::encode),
+            created_at: part.created_at,
+            payment_preimage: part.payment_preimage.map(hex::encode),
+            created_index: None,
+            updated_index: None,
+        }
+    }
+}
+
+impl From<cln::ListsendpaysPayments> for SendPayPart {
+    fn from(part: cln::ListsendpaysPayments) -> Self {
+        let status = part.status();
+        SendPayPart {
+            id: part.id,
+            groupid: Some(part.groupid),
+            partid: part.partid,
+            payment_hash: hex::encode(part.payment_hash),
+            status: status.into(),
+            amount_msat: part.amount_msat.map(Msat::from),
+            amount_sent_msat: part.amount_sent_msat.map(Msat::from),
+            destination: part.destination.map(hex::encode),
+            created_at: part.created_at,
+            payment_preimage: part.payment_preimage.map(hex::encode),
+            created_index: part.created_index,
+            updated_index: part.updated_index,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct WaitSendPayRequest {
+    pub payment_hash: String,
+    pub timeout: Option<u32>,
+    pub partid: Option<u64>,
+    pub groupid: Option<u64>,
+}
+
+impl TryFrom<WaitSendPayRequest> for cln::WaitsendpayRequest {
+    type Error = SdkError;
+
+    fn try_from(req: WaitSendPayRequest) -> Result<Self> {
+        Ok(cln::WaitsendpayRequest {
+            payment_hash: hex::decode(req.payment_hash)
+                .context("payment hash contains invalid hex value")
+                .map_err(SdkError::invalid_arg)?,
+            timeout: req.timeout,
+            partid: req.partid,
+            groupid: req.groupid,
+        })
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct SendCustomMsgRequest {
+    pub peer_id: String,
+    pub msg: String,
+}
+
+impl TryFrom<SendCustomMsgRequest> for cln::SendcustommsgRequest {
+    type Error = SdkError;
+
+    fn try_from(req: SendCustomMsgRequest) -> Result<Self> {
+        Ok(cln::SendcustommsgRequest {
+            node_id: hex::decode(req.peer_id)
+                .context("peer id contains invalid hex value")
+                .map_err(SdkError::invalid_arg)?,
+            msg: hex::decode(req.msg)
+                .context("message contains invalid hex value")
+                .map_err(SdkError::invalid_arg)?,
+        })
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct SendCustomMsgResponse {
+    pub status: String,
+}
+
+impl From<cln::SendcustommsgResponse> for SendCustomMsgResponse {
+    fn from(response: cln::SendcustommsgResponse) -> Self {
+        SendCustomMsgResponse {
+            status: response.status,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct EmergencyRecoverResponse {
+    pub stubs: Vec<String>,
+}
+
+impl From<cln::EmergencyrecoverResponse> for EmergencyRecoverResponse {
+    fn from(response: cln::EmergencyrecoverResponse) -> Self {
+        EmergencyRecoverResponse {
+            stubs: response.stubs.into_iter().map(hex::encode).collect(),
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct StaticBackupResponse {
+    pub scb: Vec<String>,
+}
+
+impl From<cln::StaticbackupResponse> for StaticBackupResponse {
+    fn from(response: cln::StaticbackupResponse) -> Self {
+        StaticBackupResponse {
+            scb: response.scb.into_iter().map(hex::encode).collect(),
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct RecoverChannelRequest {
+    pub scb: Vec<String>,
+}
+
+impl TryFrom<RecoverChannelRequest> for cln::RecoverchannelRequest {
+    type Error = SdkError;
+
+    fn try_from(req: RecoverChannelRequest) -> Result<Self> {
+        Ok(cln::RecoverchannelRequest {
+            scb: req
+                .scb
+                .into_iter()
+                .map(hex::decode)
+                .collect::<std::result::Result<_, _>>()
+                .context("scb contains invalid hex value")
+                .map_err(SdkError::invalid_arg)?,
+        })
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct RecoverChannelResponse {
+    pub stubs: Vec<String>,
+}
+
+impl From<cln::RecoverchannelResponse> for RecoverChannelResponse {
+    fn from(response: cln::RecoverchannelResponse) -> Self {
+        RecoverChannelResponse {
+            stubs: response.stubs,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct UpgradeWalletRequest {
+    pub feerate_perkw: Option<u32>,
+    pub reservedok: Option<bool>,
+}
+
+impl From<UpgradeWalletRequest> for cln::UpgradewalletRequest {
+    fn from(req: UpgradeWalletRequest) -> Self {
+        cln::UpgradewalletRequest {
+            feerate: req.feerate_perkw.map(|f| cln::Feerate {
+                style: Some(cln::feerate::Style::Perkw(f)),
+            }),
+            reservedok: req.reservedok,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct UpgradeWalletResponse {
+    pub upgraded_outs: Option<u64>,
+    pub psbt: Option<String>,
+    pub tx: Option<String>,
+    pub txid: Option<String>,
+}
+
+impl From<cln::UpgradewalletResponse> for UpgradeWalletResponse {
+    fn from(response: cln::UpgradewalletResponse) -> Self {
+        UpgradeWalletResponse {
+            upgraded_outs: response.upgraded_outs,
+            psbt: response.psbt,
+            tx: response.tx.map(hex::encode),
+            txid: response.txid.map(hex::encode),
+        }
+    }
+}
+
+pub struct GreenlightAlbyClient {
+    node: RwLock<gl_client::node::ClnClient>,
+    gl_node: RwLock<gl_client::node::Client>,
+    signer: Signer,
+    creds: gl_client::credentials::Device,
+    network: Network,
+    scheduler_config: SchedulerConfig,
+    // None when the signer runs elsewhere, e.g. started with start_signer.
+    signer_handle: Option<SignerHandle>,
+    payment_labels: LabelLocks,
+}
+
+// Parses the mnemonic and derives the signer secret, the first 32 bytes of
+// the BIP39 seed. The mnemonic, seed and secret are zeroed when dropped, but
+// the memory isn't locked, so they may still be swapped to disk, and the
+// signer keeps its own copy of the secret while it runs.
+pub(crate) fn signer_secret(mnemonic: String) -> Result<Zeroizing<Vec<u8>>> {
+    let mnemonic = Zeroizing::new(mnemonic);
+    let mnemonic = Mnemonic::from_str(&mnemonic)
         .context("failed to parse mnemonic")
         .map_err(SdkError::invalid_arg)?;
 
-    let secret = mnemonic.to_seed("")[0..32].to_vec(); // Only need the first 32 bytes
+    let seed = Zeroizing::new(mnemonic.to_seed(""));
+    Ok(Zeroizing::new(seed[0..32].to_vec()))
+}
+
+// The CLN and Greenlight clients for a node, sharing one connection so the
+// node is only scheduled once.
+struct NodeClients {
+    node: gl_client::node::ClnClient,
+    gl_node: gl_client::node::Client,
+}
 
-    let creds = Nobody::new();
+impl gl_client::node::GrpcClient for NodeClients {
+    fn new_with_inner(inner: gl_client::node::service::AuthService) -> Self {
+        NodeClients {
+            node: gl_client::node::ClnClient::new(inner.clone()),
+            gl_node: gl_client::node::Client::new(inner),
+        }
+    }
+}
 
-    let signer = Signer::new(secret, Network::Bitcoin, creds.clone())
-        .context("failed to create signer")
-        .map_err(SdkError::greenlight_api)?;
+pub async fn recover(
+    mnemonic: String,
+    network: Network,
+    scheduler_config: Option<SchedulerConfig>,
+) -> Result<GreenlightCredentials> {
+    let scheduler_config = scheduler_config.unwrap_or_default();
 
-    let scheduler = Scheduler::new(signer.node_id(), Network::Bitcoin, creds)
-        .await
-        .context("failed to create scheduler")
+    let secret = signer_secret(mnemonic)?;
+
+    let signer = Signer::new(secret.to_vec(), network.into(), Nobody::new())
+        .context("failed to create signer")
         .map_err(SdkError::greenlight_api)?;
 
+    recover_credentials(&signer, network, &scheduler_config).await
+}
+
+// Asks the scheduler to issue new device credentials for the signer's node.
+async fn recover_credentials(
+    signer: &Signer,
+    network: Network,
+    scheduler_config: &SchedulerConfig,
+) -> Result<GreenlightCredentials> {
+    let mut creds = Nobody::new();
+    if let Some(ca_cert) = scheduler_config.ca_cert.clone() {
+        creds = creds.with_ca(ca_cert);
+    }
+
+    let scheduler = Scheduler::with(
+        signer.node_id(),
+        network.into(),
+        scheduler_config.uri(),
+        creds,
+    )
+    .await
+    .context("failed to create scheduler")
+    .map_err(SdkError::greenlight_api)?;
+
     Ok(scheduler
-        .recover(&signer)
+        .recover(signer)
         .await
         .context("failed to recover credentials")
         .map_err(SdkError::greenlight_api)?
         .into())
 }
 
-pub async fn register(mnemonic: String, invite_code: String) -> Result<GreenlightCredentials> {
-    let mnemonic = Mnemonic::from_str(&mnemonic)
-        .context("failed to parse mnemonic")
-        .map_err(SdkError::invalid_arg)?;
-
-    let secret = mnemonic.to_seed("")[0..32].to_vec(); // Only need the first 32 bytes
-
-    let creds = Nobody::new();
+pub async fn register(
+    mnemonic: String,
+    invite_code: Option<String>,
+    partner_credentials: Option<PartnerCredentials>,
+    network: Network,
+    scheduler_config: Option<SchedulerConfig>,
+) -> Result<GreenlightCredentials> {
+    let scheduler_config = scheduler_config.unwrap_or_default();
+
+    let secret = signer_secret(mnemonic)?;
+
+    let mut creds = match partner_credentials {
+        Some(partner) => Nobody::with(partner.device_cert, partner.device_key),
+        None => Nobody::new(),
+    };
+    if let Some(ca_cert) = scheduler_config.ca_cert.clone() {
+        creds = creds.with_ca(ca_cert);
+    }
 
-    let signer = Signer::new(secret, Network::Bitcoin, creds.clone())
+    let signer = Signer::new(secret.to_vec(), network.into(), creds.clone())
         .context("failed to create signer")
         .map_err(SdkError::greenlight_api)?;
 
-    let scheduler = Scheduler::new(signer.node_id(), Network::Bitcoin, creds)
-        .await
-        .context("failed to create scheduler")
-        .map_err(SdkError::greenlight_api)?;
+    let scheduler = Scheduler::with(
+        signer.node_id(),
+        network.into(),
+        scheduler_config.uri(),
+        creds,
+    )
+    .await
+    .context("failed to create scheduler")
+    .map_err(SdkError::greenlight_api)?;
 
     Ok(scheduler
-        .register(&signer, Some(invite_code))
+        .register(&signer, invite_code)
         .await
         .context("failed to register node")
         .map_err(SdkError::greenlight_api)?
         .into())
 }
 
-pub async fn new_greenlight_alby_client(
+// Converts a legacy certificate and key into the combined credentials
+// format, requesting a rune for the device from the scheduler.
+pub async fn upgrade_legacy_credentials(
     mnemonic: String,
-    credentials: GreenlightCredentials,
-) -> Result<Arc<GreenlightAlbyClient>> {
+    legacy_credentials: LegacyCredentials,
+    network: Network,
+    scheduler_config: Option<SchedulerConfig>,
+) -> Result<GreenlightCredentials> {
+    let scheduler_config = scheduler_config.unwrap_or_default();
+
+    let secret = signer_secret(mnemonic)?;
+
+    let mut creds = gl_client::credentials::Device::with(
+        legacy_credentials.device_cert,
+        legacy_credentials.device_key,
+        "",
+    );
+    if let Some(ca_cert) = scheduler_config.ca_cert.clone() {
+        creds = creds.with_ca(ca_cert);
+    }
+
+    let signer = Signer::new(secret.to_vec(), network.into(), creds.clone())
+        .context("failed to create signer")
+        .map_err(SdkError::greenlight_api)?;
+
+    let scheduler = Scheduler::with(
+        signer.node_id(),
+        network.into(),
+        scheduler_config.uri(),
+        creds.clone(),
+    )
+    .await
+    .context("failed to create scheduler")
+    .map_err(SdkError::greenlight_api)?;
+
+    let creds = creds
+        .upgrade(&scheduler, &signer)
+        .await
+        .context("failed to upgrade credentials")
+        .map_err(SdkError::greenlight_api)?;
+
+    Ok(GreenlightCredentials {
+        gl_creds: hex::encode(creds.to_bytes()),
+    })
+}
+
+// Derives the node id locally, without contacting Greenlight.
+pub fn get_node_id_from_mnemonic(mnemonic: String, network: Network) -> Result<String> {
+    let secret = signer_secret(mnemonic)?;
+
+    let signer = Signer::new(secret.to_vec(), network.into(), Nobody::new())
+        .context("failed to create signer")
+        .map_err(SdkError::greenlight_api)?;
+
+    Ok(hex::encode(signer.node_id()))
+}
+
+#[cfg(feature = "credentials-inspection")]
+pub fn inspect_credentials(credentials: GreenlightCredentials) -> Result<CredentialsInfo> {
     let cred_bytes = hex::decode(&credentials.gl_creds)
         .context("failed to decode credentials")
         .map_err(SdkError::invalid_arg)?;
 
     let creds = gl_client::credentials::Device::from_bytes(&cred_bytes);
 
-    let mnemonic = Mnemonic::from_str(&mnemonic)
-        .context("failed to parse mnemonic")
+    let (_, pem) = x509_parser::pem::parse_x509_pem(&creds.cert)
+        .context("failed to parse device certificate")
+        .map_err(SdkError::invalid_arg)?;
+    let cert = pem
+        .parse_x509()
+        .context("failed to parse device certificate")
         .map_err(SdkError::invalid_arg)?;
 
-    let secret = mnemonic.to_seed("")[0..32].to_vec(); // Only need the first 32 bytes
+    Ok(CredentialsInfo {
+        not_before: cert.validity().not_before.timestamp(),
+        not_after: cert.validity().not_after.timestamp(),
+        subject: cert.subject().to_string(),
+        issuer: cert.issuer().to_string(),
+    })
+}
 
-    let signer = Signer::new(secret, Network::Bitcoin, creds.clone())
-        .context("failed to create signer")
-        .map_err(SdkError::greenlight_api)?;
+#[cfg(not(feature = "credentials-inspection"))]
+pub fn inspect_credentials(_credentials: GreenlightCredentials) -> Result<CredentialsInfo> {
+    Err(SdkError::InvalidArgument {
+        message: "glalby was built without the credentials-inspection feature".to_string(),
+    })
+}
 
-    let scheduler = Scheduler::new(signer.node_id(), Network::Bitcoin, creds)
-        .await
-        .context("failed to create scheduler")
+pub async fn new_greenlight_alby_client(
+    mnemonic: String,
+    credentials: GreenlightCredentials,
+    network: Network,
+    scheduler_config: Option<SchedulerConfig>,
+) -> Result<Arc<GreenlightAlbyClient>> {
+    open_greenlight_alby_client(mnemonic, credentials, network, scheduler_config, true).await
+}
+
+// Like new_greenlight_alby_client, but the client only runs its own signer
+// if run_signer is set. Clients without one rely on a signer started
+// separately, e.g. with start_signer, to sign for the node.
+pub(crate) async fn open_greenlight_alby_client(
+    mnemonic: String,
+    credentials: GreenlightCredentials,
+    network: Network,
+    scheduler_config: Option<SchedulerConfig>,
+    run_signer: bool,
+) -> Result<Arc<GreenlightAlbyClient>> {
+    let secret = signer_secret(mnemonic)?;
+    new_greenlight_alby_client_with_secret(
+        &secret,
+        credentials,
+        network,
+        scheduler_config,
+        run_signer,
+    )
+    .await
+}
+
+// Like new_greenlight_alby_client, but takes the 64 byte BIP39 seed instead
+// of the mnemonic. The seed is zeroed once the signer has been created; see
+// signer_secret for what that does and doesn't protect against.
+pub async fn new_greenlight_alby_client_from_seed(
+    seed: Vec<u8>,
+    credentials: GreenlightCredentials,
+    network: Network,
+    scheduler_config: Option<SchedulerConfig>,
+) -> Result<Arc<GreenlightAlbyClient>> {
+    let seed = Zeroizing::new(seed);
+    if seed.len() != 64 {
+        return Err(SdkError::invalid_arg(anyhow!(
+            "seed must be 64 bytes, got {}",
+            seed.len()
+        )));
+    }
+
+    new_greenlight_alby_client_with_secret(
+        &seed[0..32],
+        credentials,
+        network,
+        scheduler_config,
+        true,
+    )
+    .await
+}
+
+async fn new_greenlight_alby_client_with_secret(
+    secret: &[u8],
+    credentials: GreenlightCredentials,
+    network: Network,
+    scheduler_config: Option<SchedulerConfig>,
+    run_signer: bool,
+) -> Result<Arc<GreenlightAlbyClient>> {
+    let scheduler_config = scheduler_config.unwrap_or_default();
+
+    let cred_bytes = hex::decode(&credentials.gl_creds)
+        .context("failed to decode credentials")
+        .map_err(SdkError::invalid_arg)?;
+
+    let mut creds = gl_client::credentials::Device::from_bytes(&cred_bytes);
+    if let Some(ca_cert) = scheduler_config.ca_cert.clone() {
+        creds = creds.with_ca(ca_cert);
+    }
+
+    let signer = Signer::new(secret.to_vec(), network.into(), creds.clone())
+        .context("failed to create signer")
         .map_err(SdkError::greenlight_api)?;
 
-    let node = scheduler
+    let scheduler = Scheduler::with(
+        signer.node_id(),
+        network.into(),
+        scheduler_config.uri(),
+        creds.clone(),
+    )
+    .await
+    .context("failed to create scheduler")
+    .map_err(SdkError::greenlight_api)?;
+
+    let clients: NodeClients = scheduler
         .node()
         .await
         .context("failed to create node")
         .map_err(SdkError::greenlight_api)?;
 
-    let (tx, rx) = tokio::sync::mpsc::channel(1);
-    let signer_handle = tokio::spawn(async move {
-        println!("Run forever started");
-        if let Err(e) = signer.run_forever(rx).await {
-            eprintln!("Run forever error: {:?}", e);
-        }
-        println!("Run forever finished");
-    });
+    let signer_handle = run_signer.then(|| SignerHandle::spawn(signer.clone()));
 
     Ok(Arc::new(GreenlightAlbyClient {
-        node,
+        node: RwLock::new(clients.node),
+        gl_node: RwLock::new(clients.gl_node),
+        signer,
+        creds,
+        network,
+        scheduler_config,
         signer_handle,
-        shutdown: tx,
+        payment_labels: LabelLocks::default(),
     }))
 }
 
 impl GreenlightAlbyClient {
     pub async fn shutdown(&self) -> Result<ShutdownResponse> {
-        println!("Sending shutdown message");
-        self.shutdown.send(()).await.unwrap();
-
-        let mut tries = 0;
-        let max_tries = 2;
-        while !self.signer_handle.is_finished() && tries < max_tries {
-            println!("Waiting for signer to stop...");
-            time::sleep(Duration::from_millis(1000)).await;
-            tries += 1;
-        }
-        if tries == max_tries {
-            println!("Shutdown failed, aborting handle");
-            self.signer_handle.abort();
-            time::sleep(Duration::from_millis(1000)).await;
+        if let Some(signer_handle) = &self.signer_handle {
+            signer_handle.stop().await;
         }
 
-        println!("Greenlight shutdown finished");
+        log::info!("Greenlight shutdown finished");
         Ok(ShutdownResponse {})
     }
 
     pub async fn get_info(&self) -> Result<GetInfoResponse> {
-        self.node
-            .clone()
+        self.node()
             .getinfo(cln::GetinfoRequest::default())
             .await
             .context("failed to get node info")
@@ -955,8 +3087,7 @@ impl GreenlightAlbyClient {
     }
 
     pub async fn make_invoice(&self, req: MakeInvoiceRequest) -> Result<MakeInvoiceResponse> {
-        self.node
-            .clone()
+        self.node()
             .invoice(cln::InvoiceRequest::try_from(req)?)
             .await
             .context("failed to make invoice")
@@ -965,8 +3096,18 @@ impl GreenlightAlbyClient {
     }
 
     pub async fn pay(&self, req: PayRequest) -> Result<PayResponse> {
-        self.node
-            .clone()
+        let mut _label_lock = None;
+        if let Some(label) = &req.label {
+            _label_lock = Some(self.payment_labels.lock(label).await);
+            if let Some(prior) = self
+                .find_prior_payment(PaymentTarget::Invoice(&req.bolt11), label)
+                .await?
+            {
+                return Ok(prior.into());
+            }
+        }
+
+        self.node()
             .pay(cln::PayRequest::from(req))
             .await
             .context("failed to pay invoice")
@@ -975,8 +3116,18 @@ impl GreenlightAlbyClient {
     }
 
     pub async fn key_send(&self, req: KeySendRequest) -> Result<KeySendResponse> {
-        self.node
-            .clone()
+        let mut _label_lock = None;
+        if let Some(label) = &req.label {
+            _label_lock = Some(self.payment_labels.lock(label).await);
+            if let Some(prior) = self
+                .find_prior_payment(PaymentTarget::Keysend(&req.destination), label)
+                .await?
+            {
+                return Ok(prior.into());
+            }
+        }
+
+        self.node()
             .key_send(cln::KeysendRequest::try_from(req)?)
             .await
             .context("failed to send keysend")
@@ -984,19 +3135,42 @@ impl GreenlightAlbyClient {
             .map(|r| r.into_inner().into())
     }
 
+    // Channel reserves aren't part of listfunds, so they are looked up with
+    // listpeerchannels and matched by channel id.
     pub async fn list_funds(&self, req: ListFundsRequest) -> Result<ListFundsResponse> {
-        self.node
-            .clone()
-            .list_funds(cln::ListfundsRequest::from(req))
-            .await
-            .context("failed to list funds")
-            .map_err(SdkError::greenlight_api)
-            .map(|r| r.into_inner().into())
+        let mut node = self.node();
+        let mut peers_node = self.node();
+        let (funds, peer_channels) = tokio::try_join!(
+            node.list_funds(cln::ListfundsRequest::from(req)),
+            peers_node.list_peer_channels(cln::ListpeerchannelsRequest::default()),
+        )
+        .context("failed to list funds")
+        .map_err(SdkError::greenlight_api)?;
+
+        let reserves: HashMap<Vec<u8>, (Option<cln::Amount>, Option<cln::Amount>)> = peer_channels
+            .into_inner()
+            .channels
+            .into_iter()
+            .filter_map(|c| Some((c.channel_id?, (c.our_reserve_msat, c.their_reserve_msat))))
+            .collect();
+
+        let mut funds = ListFundsResponse::from(funds.into_inner());
+        for channel in &mut funds.channels {
+            let reserve = channel
+                .channel_id
+                .as_ref()
+                .and_then(|id| hex::decode(id).ok())
+                .and_then(|id| reserves.get(&id));
+            if let Some((ours, theirs)) = reserve {
+                channel.our_reserve_msat = ours.clone().map(Msat::from);
+                channel.their_reserve_msat = theirs.clone().map(Msat::from);
+            }
+        }
+        Ok(funds)
     }
 
     pub async fn connect_peer(&self, req: ConnectPeerRequest) -> Result<ConnectPeerResponse> {
-        self.node
-            .clone()
+        self.node()
             .connect_peer(cln::ConnectRequest::from(req))
             .await
             .context("failed to connect peer")
@@ -1005,8 +3179,7 @@ impl GreenlightAlbyClient {
     }
 
     pub async fn fund_channel(&self, req: FundChannelRequest) -> Result<FundChannelResponse> {
-        self.node
-            .clone()
+        self.node()
             .fund_channel(cln::FundchannelRequest::try_from(req)?)
             .await
             .context("failed to fund channel")
@@ -1015,8 +3188,7 @@ impl GreenlightAlbyClient {
     }
 
     pub async fn new_address(&self, req: NewAddressRequest) -> Result<NewAddressResponse> {
-        self.node
-            .clone()
+        self.node()
             .new_addr(cln::NewaddrRequest::from(req))
             .await
             .context("failed to request new address")
@@ -1025,28 +3197,87 @@ impl GreenlightAlbyClient {
     }
 
     pub async fn list_invoices(&self, req: ListInvoicesRequest) -> Result<ListInvoicesResponse> {
-        self.node
-            .clone()
+        let limit = match req.limit {
+            Some(limit) if req.is_filtered() => limit,
+            _ => {
+                let mut response = self.list_invoices_unfiltered(req.clone()).await?;
+                response.invoices.retain(|i| req.matches(i));
+                return Ok(response);
+            }
+        };
+
+        // The node applies the limit before the status filters, so keep
+        // reading pages by index until enough invoices matched.
+        let index = req.index.unwrap_or(ListInvoicesIndex::Created);
+        let mut start = req.start.unwrap_or(0);
+        let mut invoices = Vec::new();
+        while invoices.len() < limit as usize {
+            let page = self
+                .list_invoices_unfiltered(ListInvoicesRequest {
+                    index: Some(index),
+                    start: Some(start),
+                    ..req.clone()
+                })
+                .await?
+                .invoices;
+            let next = page
+                .iter()
+                .filter_map(|i| match index {
+                    ListInvoicesIndex::Created => i.created_index,
+                    ListInvoicesIndex::Updated => i.updated_index,
+                })
+                .max();
+            let last_page = (page.len() as u32) < limit;
+            invoices.extend(page.into_iter().filter(|i| req.matches(i)));
+
+            match next {
+                Some(next) if !last_page => start = next + 1,
+                _ => break,
+            }
+        }
+        invoices.truncate(limit as usize);
+        Ok(ListInvoicesResponse { invoices })
+    }
+
+    async fn list_invoices_unfiltered(
+        &self,
+        req: ListInvoicesRequest,
+    ) -> Result<ListInvoicesResponse> {
+        Ok(self
+            .node()
             .list_invoices(cln::ListinvoicesRequest::try_from(req)?)
             .await
             .context("failed to list invoices")
-            .map_err(SdkError::greenlight_api)
-            .map(|r| r.into_inner().into())
+            .map_err(SdkError::greenlight_api)?
+            .into_inner()
+            .into())
     }
 
+    // Served from listsendpays rather than listpays, which can't paginate
+    // and doesn't report indexes to continue from. `limit` counts payments,
+    // not parts.
     pub async fn list_payments(&self, req: ListPaymentsRequest) -> Result<ListPaymentsResponse> {
-        self.node
-            .clone()
-            .list_pays(cln::ListpaysRequest::try_from(req)?)
-            .await
-            .context("failed to list payments")
-            .map_err(SdkError::greenlight_api)
-            .map(|r| r.into_inner().into())
+        // With a limit, the walk has to go in the requested order, so that
+        // Descending returns the newest payments rather than the oldest.
+        let mut payments = match (req.limit, req.sort_direction) {
+            (Some(limit), Some(SortDirection::Descending)) => {
+                self.list_payments_backward(&req, limit).await?
+            }
+            (Some(limit), _) => self.list_payments_forward(&req, limit).await?,
+            (None, _) => self.list_payments_all(&req).await?,
+        };
+
+        if let Some(direction) = req.sort_direction {
+            payments.sort_by_key(|p| p.created_at);
+            if let SortDirection::Descending = direction {
+                payments.reverse();
+            }
+        }
+        Ok(ListPaymentsResponse { payments })
     }
 
     pub async fn sign_message(&self, req: SignMessageRequest) -> Result<SignMessageResponse> {
-        self.node
-            .clone()
+        self.node()
             .sign_message(cln::SignmessageRequest::from(req))
             .await
             .context("failed to sign message")
@@ -1055,8 +3286,7 @@ impl GreenlightAlbyClient {
     }
 
     pub async fn withdraw(&self, req: WithdrawRequest) -> Result<WithdrawResponse> {
-        self.node
-            .clone()
+        self.node()
             .withdraw(cln::WithdrawRequest::from(req))
             .await
             .context("failed to withdraw")
@@ -1065,12 +3295,524 @@ impl GreenlightAlbyClient {
     }
 
     pub async fn close(&self, req: CloseRequest) -> Result<CloseResponse> {
-        self.node
-            .clone()
+        self.node()
             .close(cln::CloseRequest::from(req))
             .await
             .context("failed to close channel")
             .map_err(SdkError::greenlight_api)
             .map(|r| r.into_inner().into())
     }
+
+    pub async fn get_log(&self, req: GetLogRequest) -> Result<GetLogResponse> {
+        self.node()
+            .get_log(cln::GetlogRequest::from(req))
+            .await
+            .context("failed to get log")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    pub async fn list_configs(&self, req: ListConfigsRequest) -> Result<ListConfigsResponse> {
+        self.node()
+            .list_configs(cln::ListconfigsRequest::from(req))
+            .await
+            .context("failed to list configs")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    pub async fn set_config(&self, req: SetConfigRequest) -> Result<SetConfigResponse> {
+        self.node()
+            .set_config(cln::SetconfigRequest::from(req))
+            .await
+            .context("failed to set config")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    pub async fn wait(&self, req: WaitRequest) -> Result<WaitResponse> {
+        self.node()
+            .wait(cln::WaitRequest::from(req))
+            .await
+            .context("failed to wait")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    pub async fn auto_clean_once(
+        &self,
+        req: AutoCleanOnceRequest,
+    ) -> Result<AutoCleanOnceResponse> {
+        self.node()
+            .auto_clean_once(cln::AutocleanonceRequest::from(req))
+            .await
+            .context("failed to run autoclean")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    pub async fn auto_clean_status(
+        &self,
+        req: AutoCleanStatusRequest,
+    ) -> Result<AutoCleanStatusResponse> {
+        self.node()
+            .auto_clean_status(cln::AutocleanstatusRequest::from(req))
+            .await
+            .context("failed to get autoclean status")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    pub async fn wait_any_invoice(
+        &self,
+        req: WaitAnyInvoiceRequest,
+    ) -> Result<WaitAnyInvoiceResponse> {
+        self.node()
+            .wait_any_invoice(cln::WaitanyinvoiceRequest::from(req))
+            .await
+            .context("failed to wait for invoice")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    pub async fn pre_approve_invoice(
+        &self,
+        req: PreApproveInvoiceRequest,
+    ) -> Result<PreApproveInvoiceResponse> {
+        self.node()
+            .pre_approve_invoice(cln::PreapproveinvoiceRequest::from(req))
+            .await
+            .context("failed to preapprove invoice")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    pub async fn pre_approve_keysend(
+        &self,
+        req: PreApproveKeysendRequest,
+    ) -> Result<PreApproveKeysendResponse> {
+        self.node()
+            .pre_approve_keysend(cln::PreapprovekeysendRequest::try_from(req)?)
+            .await
+            .context("failed to preapprove keysend")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    pub async fn trampoline_pay(&self, req: TrampolinePayRequest) -> Result<TrampolinePayResponse> {
+        self.gl_node()
+            .trampoline_pay(pb::TrampolinePayRequest::try_from(req)?)
+            .await
+            .context("failed to pay invoice via trampoline")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    pub async fn get_route(&self, req: GetRouteRequest) -> Result<GetRouteResponse> {
+        self.node()
+            .get_route(cln::GetrouteRequest::try_from(req)?)
+            .await
+            .context("failed to get route")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    pub async fn send_pay(&self, req: SendPayRequest) -> Result<SendPayPart> {
+        self.node()
+            .send_pay(cln::SendpayRequest::try_from(req)?)
+            .await
+            .context("failed to send payment part")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    pub async fn wait_send_pay(&self, req: WaitSendPayRequest) -> Result<SendPayPart> {
+        self.node()
+            .wait_send_pay(cln::WaitsendpayRequest::try_from(req)?)
+            .await
+            .context("failed to wait for payment part")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    pub async fn send_custom_msg(
+        &self,
+        req: SendCustomMsgRequest,
+    ) -> Result<SendCustomMsgResponse> {
+        self.node()
+            .send_custom_msg(cln::SendcustommsgRequest::try_from(req)?)
+            .await
+            .context("failed to send custom message")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    // Requests new device credentials for this node. The returned credentials
+    // should be persisted and used the next time the client is created.
+    pub async fn rotate_credentials(&self) -> Result<GreenlightCredentials> {
+        recover_credentials(&self.signer, self.network, &self.scheduler_config).await
+    }
+
+    // Asks the scheduler whether the node is running, without scheduling it.
+    pub async fn node_status(&self) -> Result<NodeStatus> {
+        self.scheduler_node_info(false).await
+    }
+
+    // Checks that the scheduler can be reached and accepts the credentials,
+    // without scheduling the node or calling it. Cheap enough for readiness
+    // probes.
+    pub async fn health_check(&self) -> Result<HealthCheckResponse> {
+        let status = self.scheduler_node_info(false).await?;
+        Ok(HealthCheckResponse {
+            node_id: status.node_id,
+            node_running: status.running,
+            signer_running: self.signer_handle.as_ref().map(SignerHandle::is_running),
+        })
+    }
+
+    // Starts the node ahead of expected traffic. Nodes are also scheduled
+    // implicitly by the first RPC.
+    pub async fn schedule(&self) -> Result<NodeStatus> {
+        self.scheduler_node_info(true).await
+    }
+
+    // Stops the node. It is scheduled again by the next RPC or schedule call.
+    // The node usually drops the connection before answering, so losing the
+    // connection counts as stopped.
+    pub async fn stop_node(&self) -> Result<()> {
+        let result = self
+            .node()
+            .stop(cln::StopRequest {})
+            .await
+            .context("failed to stop node")
+            .map_err(SdkError::greenlight_api);
+        match result {
+            Ok(_) | Err(SdkError::Connection { .. }) => Ok(()),
+            Err(e) => Err(e),
+        }
+    }
+
+    // Returns the static channel backup entries, which should be stored
+    // somewhere safe whenever channels are opened.
+    pub async fn static_backup(&self) -> Result<StaticBackupResponse> {
+        self.node()
+            .static_backup(cln::StaticbackupRequest {})
+            .await
+            .context("failed to get static backup")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    // Asks the peers of channels in the node's emergency backup to force
+    // close them so the funds can be swept. Returns the ids of the channels
+    // stubs were created for.
+    pub async fn emergency_recover(&self) -> Result<EmergencyRecoverResponse> {
+        self.node()
+            .emergency_recover(cln::EmergencyrecoverRequest {})
+            .await
+            .context("failed to run emergency recovery")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    // Injects individual static channel backup entries, e.g. from
+    // static_backup, so their peers are asked to force close.
+    pub async fn recover_channel(
+        &self,
+        req: RecoverChannelRequest,
+    ) -> Result<RecoverChannelResponse> {
+        self.node()
+            .recover_channel(cln::RecoverchannelRequest::try_from(req)?)
+            .await
+            .context("failed to recover channel")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    // Sweeps funds on p2sh-wrapped segwit outputs to native segwit.
+    pub async fn upgrade_wallet(&self, req: UpgradeWalletRequest) -> Result<UpgradeWalletResponse> {
+        self.node()
+            .upgrade_wallet(cln::UpgradewalletRequest::from(req))
+            .await
+            .context("failed to upgrade wallet")
+            .map_err(SdkError::greenlight_api)
+            .map(|r| r.into_inner().into())
+    }
+
+    // Schedules the node again and replaces the connection to it. Calls that
+    // are already in progress keep using the old connection.
+    pub async fn reconnect(&self) -> Result<()> {
+        let scheduler = Scheduler::with(
+            self.signer.node_id(),
+            self.network.into(),
+            self.scheduler_config.uri(),
+            self.creds.clone(),
+        )
+        .await
+        .context("failed to create scheduler")
+        .map_err(SdkError::greenlight_api)?;
+
+        let clients: NodeClients = scheduler
+            .node()
+            .await
+            .context("failed to reconnect to node")
+            .map_err(SdkError::greenlight_api)?;
+
+        *self.node.write().unwrap() = clients.node;
+        *self.gl_node.write().unwrap() = clients.gl_node;
+        Ok(())
+    }
+
+    pub(crate) fn node(&self) -> gl_client::node::ClnClient {
+        self.node.read().unwrap().clone()
+    }
+
+    pub(crate) fn gl_node(&self) -> gl_client::node::Client {
+        self.gl_node.read().unwrap().clone()
+    }
+
+    async fn scheduler_node_info(&self, schedule: bool) -> Result<NodeStatus> {
+        let scheduler = Scheduler::with(
+            self.signer.node_id(),
+            self.network.into(),
+            self.scheduler_config.uri(),
+            self.creds.clone(),
+        )
+        .await
+        .context("failed to create scheduler")
+        .map_err(SdkError::greenlight_api)?;
+
+        let info = if schedule {
+            scheduler
+                .schedule()
+                .await
+                .context("failed to schedule node")
+        } else {
+            scheduler
+                .get_node_info(false)
+                .await
+                .context("failed to get node status")
+        };
+        info.map_err(SdkError::greenlight_api).map(NodeStatus::from)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn status_error(code: tonic::Code, message: &str) -> SdkError {
+        SdkError::greenlight_api(
+            anyhow::Error::new(tonic::Status::new(code, message)).context("failed to pay"),
+        )
+    }
+
+    fn rpc_error(code: i64) -> String {
+        format!(
+            "Error calling method Pay: RpcError {{ code: Some({}), message: \"failed\", data: None }}",
+            code
+        )
+    }
+
+    #[test]
+    fn parses_cln_error_codes() {
+        assert_eq!(cln_error_code(&rpc_error(207)), Some(207));
+        assert_eq!(cln_error_code(&rpc_error(-32602)), Some(-32602));
+        assert_eq!(
+            cln_error_code("RpcError { code: None, message: \"failed\" }"),
+            None
+        );
+        assert_eq!(cln_error_code("code: Some(abc)"), None);
+        assert_eq!(cln_error_code("code: Some(207"), None);
+    }
+
+    #[test]
+    fn maps_grpc_codes() {
+        assert!(matches!(
+            status_error(tonic::Code::Unavailable, "down"),
+            SdkError::Connection { .. }
+        ));
+        assert!(matches!(
+            status_error(tonic::Code::DeadlineExceeded, "slow"),
+            SdkError::Timeout { .. }
+        ));
+        assert!(matches!(
+            status_error(tonic::Code::Unauthenticated, "who"),
+            SdkError::Authentication { .. }
+        ));
+        assert!(matches!(
+            status_error(tonic::Code::PermissionDenied, "no"),
+            SdkError::Authentication { .. }
+        ));
+        assert!(matches!(
+            status_error(tonic::Code::NotFound, "gone"),
+            SdkError::GreenlightApi {
+                grpc_code: Some(GrpcCode::NotFound),
+                ..
+            }
+        ));
+        assert!(matches!(
+            SdkError::greenlight_api(anyhow!("no status")),
+            SdkError::GreenlightApi {
+                grpc_code: None,
+                ..
+            }
+        ));
+    }
+
+    #[test]
+    fn maps_cln_error_codes() {
+        let error = |code| status_error(tonic::Code::Unknown, &rpc_error(code));
+        assert!(matches!(
+            error(PAY_IN_PROGRESS),
+            SdkError::PaymentPending { .. }
+        ));
+        assert!(matches!(
+            error(PAY_ROUTE_NOT_FOUND),
+            SdkError::RouteNotFound { .. }
+        ));
+        assert!(matches!(
+            error(PAY_INVOICE_EXPIRED),
+            SdkError::InvoiceExpired { .. }
+        ));
+        assert!(matches!(
+            error(FUND_CANNOT_AFFORD),
+            SdkError::InsufficientFunds { .. }
+        ));
+        assert!(matches!(
+            error(210),
+            SdkError::GreenlightApi {
+                grpc_code: Some(GrpcCode::Unknown),
+                ..
+            }
+        ));
+        // The connection state wins over whatever CLN reported.
+        assert!(matches!(
+            status_error(tonic::Code::Unavailable, &rpc_error(PAY_IN_PROGRESS)),
+            SdkError::Connection { .. }
+        ));
+    }
+
+    fn payment(status: ListPaymentsStatus, created_at: u64) -> ListPaymentsPayment {
+        ListPaymentsPayment {
+            payment_hash: "00".to_string(),
+            status,
+            destination: None,
+            created_at,
+            completed_at: None,
+            label: None,
+            bolt11: None,
+            description: None,
+            bolt12: None,
+            amount_msat: None,
+            amount_sent_msat: None,
+            preimage: None,
+            number_of_parts: None,
+            erroronion: None,
+            created_index: None,
+            updated_index: None,
+        }
+    }
+
+    #[test]
+    fn matches_status_and_creation_time() {
+        let req = ListPaymentsRequest {
+            bolt11: None,
+            payment_hash: None,
+            status: Some(ListPaymentsStatus::Complete),
+            index: None,
+            start: None,
+            limit: None,
+            created_from: Some(100),
+            created_to: Some(200),
+            sort_direction: None,
+        };
+        assert!(req.matches(&payment(ListPaymentsStatus::Complete, 100)));
+        assert!(req.matches(&payment(ListPaymentsStatus::Complete, 200)));
+        assert!(!req.matches(&payment(ListPaymentsStatus::Complete, 99)));
+        assert!(!req.matches(&payment(ListPaymentsStatus::Complete, 201)));
+        assert!(!req.matches(&payment(ListPaymentsStatus::Failed, 150)));
+
+        let any = ListPaymentsRequest {
+            status: None,
+            created_from: None,
+            created_to: None,
+            ..req
+        };
+        assert!(any.matches(&payment(ListPaymentsStatus::Pending, 0)));
+    }
+
+    fn output(msat: u64, status: ListFundsOutputStatus, reserved: bool) -> ListFundsOutput {
+        ListFundsOutput {
+            txid: "00".to_string(),
+            output: 0,
+            amount_msat: Some(Msat(msat)),
+            scriptpubkey: String::new(),
+            address: None,
+            redeemscript: None,
+            status,
+            reserved,
+            blockheight: None,
+        }
+    }
+
+    fn channel(state: ChannelState, connected: bool) -> ListFundsChannel {
+        ListFundsChannel {
+            peer_id: "02aa".to_string(),
+            our_amount_msat: Some(Msat(600_000)),
+            amount_msat: Some(Msat(1_000_000)),
+            funding_txid: "00".to_string(),
+            funding_output: 0,
+            connected,
+            state,
+            channel_id: None,
+            short_channel_id: None,
+            our_reserve_msat: None,
+            their_reserve_msat: None,
+        }
+    }
+
+    #[test]
+    fn summarizes_outputs() {
+        let funds = ListFundsResponse {
+            outputs: vec![
+                output(1_000, ListFundsOutputStatus::Confirmed, false),
+                output(2_000, ListFundsOutputStatus::Unconfirmed, false),
+                output(4_000, ListFundsOutputStatus::Immature, false),
+                output(8_000, ListFundsOutputStatus::Confirmed, true),
+                output(16_000, ListFundsOutputStatus::Spent, false),
+            ],
+            channels: Vec::new(),
+        };
+        let summary = funds.summary();
+        assert_eq!(summary.onchain_confirmed_msat, Msat(1_000));
+        assert_eq!(summary.onchain_unconfirmed_msat, Msat(6_000));
+        assert_eq!(summary.onchain_reserved_msat, Msat(8_000));
+    }
+
+    #[test]
+    fn summarizes_channels() {
+        let funds = ListFundsResponse {
+            outputs: Vec::new(),
+            channels: vec![
+                channel(ChannelState::ChanneldNormal, true),
+                ListFundsChannel {
+                    our_reserve_msat: Some(Msat(50_000)),
+                    their_reserve_msat: Some(Msat(0)),
+                    ..channel(ChannelState::ChanneldNormal, true)
+                },
+                channel(ChannelState::ChanneldNormal, false),
+                channel(ChannelState::ChanneldAwaitingSplice, true),
+                channel(ChannelState::ChanneldAwaitingLockin, true),
+                channel(ChannelState::Onchain, false),
+            ],
+        };
+        let summary = funds.summary();
+        // 600k less the default 1% reserve, plus 600k less the reported one.
+        assert_eq!(summary.spendable_msat, Msat(590_000 + 550_000));
+        assert_eq!(summary.receivable_msat, Msat(390_000 + 400_000));
+        assert_eq!(summary.unavailable_channels_msat, Msat(1_200_000));
+        assert_eq!(summary.pending_channels_msat, Msat(600_000));
+        assert_eq!(summary.closing_channels_msat, Msat(600_000));
+    }
 }
@@ -0,0 +1,237 @@
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex, Weak};
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use anyhow::Context;
+use gl_client::pb::cln;
+use tokio::sync::OwnedMutexGuard;
+
+use crate::greenlight_alby_client::{
+    GreenlightAlbyClient, ListPaymentsPayment, ListPaymentsRequest, ListPaymentsStatus, Result,
+    SdkError, SortDirection,
+};
+
+// Keysends can't be looked up by anything but scanning payments, so labels
+// are only checked against the most recent keysends. Retries with the same
+// label are expected well within this window.
+const KEYSEND_LOOKBACK_SECS: u64 = 24 * 60 * 60;
+const KEYSEND_LOOKBACK_PAYMENTS: u32 = 1000;
+
+// What a labeled payment pays: an invoice, or a keysend to a node.
+pub(crate) enum PaymentTarget<'a> {
+    Invoice(&'a str),
+    Keysend(&'a str),
+}
+
+// Whether an earlier payment answers a new one with the same label. A keysend
+// label only matches keysends to the same destination, so it can't collide
+// with an invoice payment that happens to use the same label.
+pub(crate) fn is_prior_payment(
+    payment: &ListPaymentsPayment,
+    target: &PaymentTarget,
+    label: &str,
+) -> bool {
+    if payment.label.as_deref() != Some(label) || payment.status == ListPaymentsStatus::Failed {
+        return false;
+    }
+    match target {
+        PaymentTarget::Invoice(bolt11) => payment.bolt11.as_deref() == Some(*bolt11),
+        PaymentTarget::Keysend(destination) => {
+            payment.bolt11.is_none()
+                && payment.bolt12.is_none()
+                && payment
+                    .destination
+                    .as_deref()
+                    .map_or(false, |d| d.eq_ignore_ascii_case(destination))
+        }
+    }
+}
+
+// Serializes payments that share a label, so two concurrent calls can't both
+// find no prior payment and both pay.
+#[derive(Default)]
+pub(crate) struct LabelLocks(Mutex<HashMap<String, Weak<tokio::sync::Mutex<()>>>>);
+
+impl LabelLocks {
+    pub(crate) async fn lock(&self, label: &str) -> OwnedMutexGuard<()> {
+        let lock = {
+            let mut locks = self.0.lock().unwrap();
+            locks.retain(|_, lock| lock.strong_count() > 0);
+            match locks.get(label).and_then(Weak::upgrade) {
+                Some(lock) => lock,
+                None => {
+                    let lock = Arc::new(tokio::sync::Mutex::new(()));
+                    locks.insert(label.to_string(), Arc::downgrade(&lock));
+                    lock
+                }
+            }
+        };
+        lock.lock_owned().await
+    }
+}
+
+impl GreenlightAlbyClient {
+    // Looks up an earlier payment with the same label, which callers use as
+    // an idempotency key. Completed payments are returned so they are not
+    // paid twice, pending ones are rejected and failed ones may be retried.
+    // Hold the label's lock from this check until the payment was sent.
+    pub(crate) async fn find_prior_payment(
+        &self,
+        target: PaymentTarget<'_>,
+        label: &str,
+    ) -> Result<Option<ListPaymentsPayment>> {
+        let payments: Vec<ListPaymentsPayment> = match target {
+            PaymentTarget::Invoice(bolt11) => self
+                .node()
+                .list_pays(cln::ListpaysRequest {
+                    bolt11: Some(bolt11.to_string()),
+                    payment_hash: None,
+                    status: None,
+                })
+                .await
+                .context("failed to look up prior payments")
+                .map_err(SdkError::greenlight_api)?
+                .into_inner()
+                .pays
+                .into_iter()
+                .map(ListPaymentsPayment::from)
+                .collect(),
+            PaymentTarget::Keysend(_) => {
+                let now = SystemTime::now()
+                    .duration_since(UNIX_EPOCH)
+                    .unwrap_or_default()
+                    .as_secs();
+                self.list_payments_backward(
+                    &ListPaymentsRequest {
+                        bolt11: None,
+                        payment_hash: None,
+                        status: None,
+                        index: None,
+                        start: None,
+                        limit: None,
+                        created_from: Some(now.saturating_sub(KEYSEND_LOOKBACK_SECS)),
+                        created_to: None,
+                        sort_direction: Some(SortDirection::Descending),
+                    },
+                    KEYSEND_LOOKBACK_PAYMENTS,
+                )
+                .await?
+            }
+        };
+
+        match payments
+            .into_iter()
+            .find(|p| is_prior_payment(p, &target, label))
+        {
+            Some(p) if p.status == ListPaymentsStatus::Pending => Err(SdkError::PaymentPending {
+                message: format!("payment with label {} is already pending", label),
+            }),
+            prior => Ok(prior),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::time::Duration;
+
+    use super::*;
+    use crate::amount::Msat;
+
+    const DESTINATION: &str = "02aa";
+    const BOLT11: &str = "lnbc1";
+
+    fn payment(
+        label: &str,
+        bolt11: Option<&str>,
+        status: ListPaymentsStatus,
+    ) -> ListPaymentsPayment {
+        ListPaymentsPayment {
+            payment_hash: "00".to_string(),
+            status,
+            destination: Some(DESTINATION.to_string()),
+            created_at: 0,
+            completed_at: None,
+            label: Some(label.to_string()),
+            bolt11: bolt11.map(String::from),
+            description: None,
+            bolt12: None,
+            amount_msat: Some(Msat(1000)),
+            amount_sent_msat: Some(Msat(1000)),
+            preimage: None,
+            number_of_parts: Some(1),
+            erroronion: None,
+            created_index: Some(1),
+            updated_index: Some(1),
+        }
+    }
+
+    #[test]
+    fn keysend_label_does_not_match_invoice_payment() {
+        let invoice = payment("a", Some(BOLT11), ListPaymentsStatus::Complete);
+        assert!(!is_prior_payment(
+            &invoice,
+            &PaymentTarget::Keysend(DESTINATION),
+            "a"
+        ));
+        assert!(is_prior_payment(
+            &invoice,
+            &PaymentTarget::Invoice(BOLT11),
+            "a"
+        ));
+    }
+
+    #[test]
+    fn keysend_label_matches_same_destination_only() {
+        let keysend = payment("a", None, ListPaymentsStatus::Complete);
+        assert!(is_prior_payment(
+            &keysend,
+            &PaymentTarget::Keysend("02AA"),
+            "a"
+        ));
+        assert!(!is_prior_payment(
+            &keysend,
+            &PaymentTarget::Keysend("03bb"),
+            "a"
+        ));
+        assert!(!is_prior_payment(
+            &keysend,
+            &PaymentTarget::Keysend(DESTINATION),
+            "b"
+        ));
+    }
+
+    #[test]
+    fn failed_payments_can_be_retried() {
+        let failed = payment("a", None, ListPaymentsStatus::Failed);
+        assert!(!is_prior_payment(
+            &failed,
+            &PaymentTarget::Keysend(DESTINATION),
+            "a"
+        ));
+    }
+
+    #[tokio::test]
+    async fn label_lock_serializes_same_label() {
+        let locks = Arc::new(LabelLocks::default());
+        let guard = locks.lock("a").await;
+
+        // A different label is not blocked.
+        tokio::time::timeout(Duration::from_millis(100), locks.lock("b"))
+            .await
+            .expect("other label was blocked");
+
+        let waiter = tokio::spawn({
+            let locks = locks.clone();
+            async move { locks.lock("a").await }
+        });
+        tokio::time::sleep(Duration::from_millis(50)).await;
+        assert!(!waiter.is_finished());
+
+        drop(guard);
+        tokio::time::timeout(Duration::from_millis(100), waiter)
+            .await
+            .expect("same label was not released")
+            .unwrap();
+    }
+}
@@ -0,0 +1,82 @@
+use std::fmt::Debug;
+use std::future::Future;
+use std::sync::{Arc, RwLock};
+use std::time::Instant;
+
+use crate::greenlight_alby_client::{Result, SdkError};
+
+#[derive(Clone, Debug)]
+pub struct CallInfo {
+    pub method: String,
+    pub request: Option<String>,
+    pub response: Option<String>,
+    pub error: Option<String>,
+    pub duration_ms: u64,
+}
+
+pub trait CallInterceptor: Send + Sync {
+    // Called before every call. Returning a reason rejects the call, which
+    // then fails with Rejected, e.g. to enforce rate limits.
+    fn before_call(&self, method: String, request: Option<String>) -> Option<String>;
+
+    // Called once the call has finished, including rejected calls.
+    fn after_call(&self, info: CallInfo);
+}
+
+// Interceptors run in the order they were added before a call and in
+// reverse order after it, so each one wraps the ones added after it. They
+// are called without holding the lock, so they may add interceptors or
+// make calls themselves.
+pub(crate) struct Interceptors {
+    interceptors: RwLock<Vec<Arc<dyn CallInterceptor>>>,
+}
+
+impl Interceptors {
+    pub(crate) fn new() -> Self {
+        Interceptors {
+            interceptors: RwLock::new(Vec::new()),
+        }
+    }
+
+    pub(crate) fn add(&self, interceptor: Box<dyn CallInterceptor>) {
+        self.interceptors.write().unwrap().push(interceptor.into());
+    }
+
+    pub(crate) async fn intercept<T: Debug>(
+        &self,
+        method: &str,
+        request: Option<&dyn Debug>,
+        call: impl Future<Output = Result<T>>,
+    ) -> Result<T> {
+        // Interceptors added during the call only see later calls.
+        let interceptors = self.interceptors.read().unwrap().clone();
+        if interceptors.is_empty() {
+            return call.await;
+        }
+
+        let request = request.map(|request| format!("{:?}", request));
+        let start = Instant::now();
+        let rejection = interceptors
+            .iter()
+            .find_map(|interceptor| interceptor.before_call(method.to_string(), request.clone()));
+        let result = match rejection {
+            Some(reason) => Err(SdkError::Rejected {
+                message: format!("call was rejected by interceptor: {}", reason),
+            }),
+            None => call.await,
+        };
+
+        let info = CallInfo {
+            method: method.to_string(),
+            request,
+            response: result.as_ref().ok().map(|r| format!("{:?}", r)),
+            error: result.as_ref().err().map(|e| e.to_string()),
+            duration_ms: start.elapsed().as_millis() as u64,
+        };
+        for interceptor in interceptors.iter().rev() {
+            interceptor.after_call(info.clone());
+        }
+
+        result
+    }
+}
@@ -0,0 +1,114 @@
+use std::sync::{Arc, Mutex, Weak};
+use std::time::{Duration, Instant};
+
+use tokio::task::JoinHandle;
+
+use crate::greenlight_alby_client::GreenlightAlbyClient;
+use crate::reconnect::Reconnector;
+
+const DEFAULT_INTERVAL_SECS: u32 = 30;
+
+#[derive(Clone, Debug)]
+pub struct KeepalivePolicy {
+    // How often an idle connection is pinged.
+    pub interval_secs: u32,
+    // After this long without calls pinging stops, so the connection goes
+    // cold and is re-established by the next call. Unset keeps pinging for
+    // as long as the client lives.
+    pub idle_timeout_secs: Option<u32>,
+}
+
+impl Default for KeepalivePolicy {
+    fn default() -> Self {
+        KeepalivePolicy {
+            interval_secs: DEFAULT_INTERVAL_SECS,
+            idle_timeout_secs: None,
+        }
+    }
+}
+
+// Keeps the node connection warm by pinging it with getinfo whenever no other
+// call went through within the interval. Pings go through the reconnector, so
+// a dropped connection is re-established in the background if a reconnect
+// policy is set.
+//
+// Pings are not calls: they bypass the call tracker, interceptors, debug
+// capture and retries, so they don't show up in metrics or count against
+// rate limits, and don't reset the idle time. Each ping is limited to one
+// interval instead of the client's timeout, and close_client stops them
+// rather than waiting for one in progress.
+pub(crate) struct Keepalive {
+    last_activity: Arc<Mutex<Instant>>,
+    task: Mutex<Option<JoinHandle<()>>>,
+}
+
+impl Keepalive {
+    pub(crate) fn new() -> Self {
+        Keepalive {
+            last_activity: Arc::new(Mutex::new(Instant::now())),
+            task: Mutex::new(None),
+        }
+    }
+
+    pub(crate) fn touch(&self) {
+        *self.last_activity.lock().unwrap() = Instant::now();
+    }
+
+    pub(crate) fn set_policy(
+        &self,
+        runtime: &tokio::runtime::Runtime,
+        policy: Option<KeepalivePolicy>,
+        client: Weak<GreenlightAlbyClient>,
+        reconnector: Arc<Reconnector>,
+    ) {
+        self.stop();
+        if let Some(policy) = policy {
+            *self.task.lock().unwrap() =
+                Some(runtime.spawn(run(policy, self.last_activity.clone(), client, reconnector)));
+        }
+    }
+
+    pub(crate) fn stop(&self) {
+        if let Some(task) = self.task.lock().unwrap().take() {
+            task.abort();
+        }
+    }
+}
+
+impl Drop for Keepalive {
+    fn drop(&mut self) {
+        self.stop();
+    }
+}
+
+async fn run(
+    policy: KeepalivePolicy,
+    last_activity: Arc<Mutex<Instant>>,
+    client: Weak<GreenlightAlbyClient>,
+    reconnector: Arc<Reconnector>,
+) {
+    let interval = Duration::from_secs(policy.interval_secs.max(1).into());
+    let idle_timeout = policy
+        .idle_timeout_secs
+        .map(|t| Duration::from_secs(t.into()));
+
+    loop {
+        tokio::time::sleep(interval).await;
+
+        let idle = last_activity.lock().unwrap().elapsed();
+        if idle < interval || idle_timeout.map_or(false, |t| idle >= t) {
+            continue;
+        }
+
+        let client = match client.upgrade() {
+            Some(client) => client,
+            None => return,
+        };
+        let ping = reconnector.read(&client, || client.get_info());
+        match tokio::time::timeout(interval, ping).await {
+            Ok(Ok(_)) => {}
+            Ok(Err(e)) => log::debug!("Keepalive ping failed: {}", e),
+            Err(_) => log::debug!("Keepalive ping timed out"),
+        }
+    }
+}
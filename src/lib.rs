@@ -1,109 +1,959 @@
-use std::sync::Arc;
+use std::fmt::Debug;
+use std::future::Future;
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
 
+use futures::future::join_all;
 use once_cell::sync::Lazy;
 
+mod amount;
+mod batch;
+mod calls;
+mod credentials;
+mod debug_capture;
+mod events;
 mod greenlight_alby_client;
+mod idempotency;
+mod interceptor;
+mod keepalive;
+mod logger;
+mod pager;
+mod reconnect;
+mod retry;
+mod signer;
+mod transactions;
+mod version;
+use calls::CallTracker;
+use debug_capture::{DebugCapture, DebugCaptureInterceptor};
 use greenlight_alby_client::{
-    new_greenlight_alby_client, GreenlightAlbyClient, GreenlightCredentials, Result, SdkError,
+    new_greenlight_alby_client, new_greenlight_alby_client_from_seed, open_greenlight_alby_client,
+    GreenlightAlbyClient, GreenlightCredentials, Result, SdkError,
 };
+use interceptor::Interceptors;
+use keepalive::Keepalive;
+use pager::{ListInvoicesPager, ListPaymentsPager};
+use reconnect::Reconnector;
+use retry::retry;
+use signer::SignerHandle;
 
 pub use greenlight_alby_client::{
-    AmountOrAll, CloseRequest, CloseResponse, ConnectPeerRequest, ConnectPeerResponse,
-    FundChannelRequest, FundChannelResponse, GetInfoResponse, KeySendRequest, KeySendResponse,
-    ListFundsChannel, ListFundsOutput, ListFundsRequest, ListFundsResponse, ListInvoicesIndex,
-    ListInvoicesInvoice, ListInvoicesInvoicePaidOutpoint, ListInvoicesRequest,
-    ListInvoicesResponse, ListPaymentsPayment, ListPaymentsRequest, ListPaymentsResponse,
-    ListPaymentsStatus, MakeInvoiceRequest, MakeInvoiceResponse, NewAddressRequest,
-    NewAddressResponse, NewAddressType, PayRequest, PayResponse, ShutdownResponse,
-    SignMessageRequest, SignMessageResponse, TlvEntry, WithdrawRequest, WithdrawResponse,
+    AddressType, AmountOrAll, AutoCleanOnceRequest, AutoCleanOnceResponse, AutoCleanOnceResult,
+    AutoCleanStatus, AutoCleanStatusRequest, AutoCleanStatusResponse, AutoCleanSubsystem,
+    ChannelState, CloseRequest, CloseResponse, ConnectDirection, ConnectPeerAddress,
+    ConnectPeerRequest, ConnectPeerResponse, CredentialsInfo, EmergencyRecoverResponse, Forward,
+    ForwardStatus, ForwardStyle, FundChannelRequest, FundChannelResponse, FundsSummary,
+    GetInfoAddress, GetInfoBinding, GetInfoResponse, GetLogEntry, GetLogLevel, GetLogRequest,
+    GetLogResponse, GetRouteRequest, GetRouteResponse, GrpcCode, HealthCheckResponse,
+    KeySendRequest, KeySendResponse, LegacyCredentials, ListConfigsRequest, ListConfigsResponse,
+    ListFundsChannel, ListFundsOutput, ListFundsOutputStatus, ListFundsRequest, ListFundsResponse,
+    ListInvoicesIndex, ListInvoicesInvoice, ListInvoicesInvoicePaidOutpoint, ListInvoicesRequest,
+    ListInvoicesResponse, ListInvoicesStatus, ListPaymentsIndex, ListPaymentsPayment,
+    ListPaymentsRequest, ListPaymentsResponse, ListPaymentsStatus, MakeInvoiceRequest,
+    MakeInvoiceResponse, Network, NewAddressRequest, NewAddressResponse, NewAddressType,
+    NodeStatus, PartnerCredentials, PayRequest, PayResponse, PreApproveInvoiceRequest,
+    PreApproveInvoiceResponse, PreApproveKeysendRequest, PreApproveKeysendResponse,
+    RecoverChannelRequest, RecoverChannelResponse, RouteHop, SchedulerConfig, SendCustomMsgRequest,
+    SendCustomMsgResponse, SendPayPart, SendPayRequest, SetConfigRequest, SetConfigResponse,
+    ShutdownResponse, SignMessageRequest, SignMessageResponse, SortDirection, StaticBackupResponse,
+    TlvEntry, TrampolinePayRequest, TrampolinePayResponse, UpgradeWalletRequest,
+    UpgradeWalletResponse, WaitAnyInvoiceRequest, WaitAnyInvoiceResponse, WaitDetails,
+    WaitIndexname, WaitRequest, WaitResponse, WaitSendPayRequest, WaitSubsystem, WithdrawRequest,
+    WithdrawResponse,
+};
+
+pub use amount::Msat;
+
+pub use batch::{BatchRequest, BatchResponse};
+
+pub use calls::CancelToken;
+
+pub use interceptor::{CallInfo, CallInterceptor};
+
+pub use keepalive::KeepalivePolicy;
+
+pub use logger::{LogEntry, LogLevel, LogListener};
+
+pub use reconnect::{ConnectionState, ConnectionStateListener, ReconnectPolicy};
+
+pub use retry::{RetryPolicy, RetryableError};
+
+pub use transactions::{
+    ListAllTransactionsRequest, ListAllTransactionsResponse, Transaction, TransactionType,
+};
+pub use version::VersionInfo;
+
+pub use events::{
+    BackpressurePolicy, EventListener, EventStreamConfig, EventSubscription, NodeEvent,
 };
 
 static RT: Lazy<tokio::runtime::Runtime> = Lazy::new(|| tokio::runtime::Runtime::new().unwrap());
 
 pub struct BlockingGreenlightAlbyClient {
     greenlight_alby_client: Arc<GreenlightAlbyClient>,
+    calls: Arc<CallTracker>,
+    reconnector: Arc<Reconnector>,
+    retry_policy: Arc<Mutex<Option<RetryPolicy>>>,
+    interceptors: Arc<Interceptors>,
+    debug_capture: Arc<DebugCapture>,
+    keepalive: Arc<Keepalive>,
+    timeout: Mutex<Option<Duration>>,
+    cancel_token: Option<Arc<CancelToken>>,
 }
 
 impl BlockingGreenlightAlbyClient {
+    fn new(greenlight_alby_client: Arc<GreenlightAlbyClient>) -> Self {
+        BlockingGreenlightAlbyClient {
+            greenlight_alby_client,
+            calls: Arc::new(CallTracker::new()),
+            reconnector: Arc::new(Reconnector::new()),
+            retry_policy: Arc::new(Mutex::new(None)),
+            interceptors: Arc::new(Interceptors::new()),
+            debug_capture: Arc::new(DebugCapture::new()),
+            keepalive: Arc::new(Keepalive::new()),
+            timeout: Mutex::new(None),
+            cancel_token: None,
+        }
+    }
+
+    fn run<T: Debug>(
+        &self,
+        method: &str,
+        request: Option<&dyn Debug>,
+        call: impl Future<Output = Result<T>>,
+    ) -> Result<T> {
+        let timeout = *self.timeout.lock().unwrap();
+        self.keepalive.touch();
+        rt().block_on(self.interceptors.intercept(
+            method,
+            request,
+            self.calls.run(call, timeout, self.cancel_token.as_deref()),
+        ))
+    }
+
+    // For calls that change state on the node, like payments. A call that
+    // failed because the connection was lost may still have reached the
+    // node, so it is never sent again: the node is reconnected for the next
+    // call and the error is returned.
+    fn call<T: Debug>(
+        &self,
+        method: &str,
+        request: Option<&dyn Debug>,
+        call: impl Future<Output = Result<T>>,
+    ) -> Result<T> {
+        self.run(
+            method,
+            request,
+            self.reconnector
+                .call_once(&self.greenlight_alby_client, call),
+        )
+    }
+
+    // For read-only calls, which are retried according to the retry policy.
+    fn read<T: Debug, F, Fut>(
+        &self,
+        method: &str,
+        request: Option<&dyn Debug>,
+        call: F,
+    ) -> Result<T>
+    where
+        F: Fn() -> Fut,
+        Fut: Future<Output = Result<T>>,
+    {
+        let retry_policy = self.retry_policy.lock().unwrap().clone();
+        self.run(method, request, self.retry_read(retry_policy, call))
+    }
+
+    async fn retry_read<T, F, Fut>(&self, retry_policy: Option<RetryPolicy>, call: F) -> Result<T>
+    where
+        F: Fn() -> Fut,
+        Fut: Future<Output = Result<T>>,
+    {
+        retry(retry_policy, || {
+            self.reconnector.read(&self.greenlight_alby_client, &call)
+        })
+        .await
+    }
+
+    // When set, calls that fail because the node can't be reached re-schedule
+    // it, backing off between attempts. Read-only calls are retried once
+    // reconnected; calls that change state, like payments, return the error
+    // since they may have reached the node.
+    pub fn set_reconnect_policy(&self, policy: Option<ReconnectPolicy>) {
+        self.reconnector.set_policy(policy);
+    }
+
+    pub fn set_retry_policy(&self, policy: Option<RetryPolicy>) {
+        *self.retry_policy.lock().unwrap() = policy;
+    }
+
+    // Interceptors see every call made through this client and the clients
+    // derived from it, e.g. for logging, auditing or rate limiting.
+    pub fn add_call_interceptor(&self, interceptor: Box<dyn CallInterceptor>) {
+        self.interceptors.add(interceptor);
+    }
+
+    // Keeps the last `capacity` calls, with secrets such as preimages and
+    // credentials redacted, until debug capture is disabled again.
+    pub fn enable_debug_capture(&self, capacity: u32) {
+        self.debug_capture.enable(capacity);
+        if self.debug_capture.register() {
+            self.interceptors.add(Box::new(DebugCaptureInterceptor(
+                self.debug_capture.clone(),
+            )));
+        }
+    }
+
+    pub fn disable_debug_capture(&self) {
+        self.debug_capture.disable();
+    }
+
+    pub fn debug_captured_calls(&self) -> Vec<CallInfo> {
+        self.debug_capture.calls()
+    }
+
+    pub fn set_connection_state_listener(&self, listener: Box<dyn ConnectionStateListener>) {
+        self.reconnector.set_listener(listener);
+    }
+
+    // Pings the node while the client is idle so the connection stays up,
+    // instead of being re-established after the node was descheduled. Pings
+    // are not seen by interceptors or debug capture.
+    pub fn set_keepalive_policy(&self, policy: Option<KeepalivePolicy>) {
+        self.keepalive.set_policy(
+            rt(),
+            policy,
+            Arc::downgrade(&self.greenlight_alby_client),
+            self.reconnector.clone(),
+        );
+    }
+
+    pub fn reconnect(&self) -> Result<()> {
+        self.run("reconnect", None, self.greenlight_alby_client.reconnect())
+    }
+
+    // Applies to every call made through this client, unless overridden with
+    // with_timeout.
+    pub fn set_default_timeout(&self, timeout_secs: Option<u32>) {
+        *self.timeout.lock().unwrap() = timeout_secs.map(|t| Duration::from_secs(t.into()));
+    }
+
+    // A client for the same node that shares the connection, call tracking,
+    // interceptors and policies, and starts with the current timeout.
+    fn share(&self) -> BlockingGreenlightAlbyClient {
+        BlockingGreenlightAlbyClient {
+            greenlight_alby_client: self.greenlight_alby_client.clone(),
+            calls: self.calls.clone(),
+            reconnector: self.reconnector.clone(),
+            retry_policy: self.retry_policy.clone(),
+            interceptors: self.interceptors.clone(),
+            debug_capture: self.debug_capture.clone(),
+            keepalive: self.keepalive.clone(),
+            timeout: Mutex::new(*self.timeout.lock().unwrap()),
+            cancel_token: self.cancel_token.clone(),
+        }
+    }
+
+    // Returns a client for the same node whose calls use the given timeout.
+    pub fn with_timeout(&self, timeout_secs: u32) -> Arc<BlockingGreenlightAlbyClient> {
+        Arc::new(BlockingGreenlightAlbyClient {
+            timeout: Mutex::new(Some(Duration::from_secs(timeout_secs.into()))),
+            ..self.share()
+        })
+    }
+
+    // Returns a client for the same node whose calls fail with Cancelled as
+    // soon as the token is cancelled.
+    pub fn with_cancel_token(
+        &self,
+        cancel_token: Arc<CancelToken>,
+    ) -> Arc<BlockingGreenlightAlbyClient> {
+        Arc::new(BlockingGreenlightAlbyClient {
+            cancel_token: Some(cancel_token),
+            ..self.share()
+        })
+    }
+
     pub fn shutdown(&self) -> Result<ShutdownResponse> {
         rt().block_on(self.greenlight_alby_client.shutdown())
     }
 
+    // Waits up to timeout_secs for calls in progress to finish, cancels the
+    // rest and stops the signer. Calls made after close_client fail.
+    pub fn close_client(&self, timeout_secs: u32) -> Result<ShutdownResponse> {
+        rt().block_on(async {
+            self.calls
+                .close(Duration::from_secs(timeout_secs.into()))
+                .await;
+            self.keepalive.stop();
+            self.greenlight_alby_client.shutdown().await
+        })
+    }
+
     pub fn get_info(&self) -> Result<GetInfoResponse> {
-        rt().block_on(self.greenlight_alby_client.get_info())
+        self.read("get_info", None, || self.greenlight_alby_client.get_info())
     }
 
     pub fn make_invoice(&self, req: MakeInvoiceRequest) -> Result<MakeInvoiceResponse> {
-        rt().block_on(self.greenlight_alby_client.make_invoice(req))
+        self.call(
+            "make_invoice",
+            Some(&req),
+            self.greenlight_alby_client.make_invoice(req.clone()),
+        )
     }
 
     pub fn pay(&self, req: PayRequest) -> Result<PayResponse> {
-        rt().block_on(self.greenlight_alby_client.pay(req))
+        self.call(
+            "pay",
+            Some(&req),
+            self.greenlight_alby_client.pay(req.clone()),
+        )
     }
 
     pub fn key_send(&self, req: KeySendRequest) -> Result<KeySendResponse> {
-        rt().block_on(self.greenlight_alby_client.key_send(req))
+        self.call(
+            "key_send",
+            Some(&req),
+            self.greenlight_alby_client.key_send(req.clone()),
+        )
     }
 
     pub fn list_funds(&self, req: ListFundsRequest) -> Result<ListFundsResponse> {
-        rt().block_on(self.greenlight_alby_client.list_funds(req))
+        self.read("list_funds", Some(&req), || {
+            self.greenlight_alby_client.list_funds(req.clone())
+        })
     }
 
     pub fn connect_peer(&self, req: ConnectPeerRequest) -> Result<ConnectPeerResponse> {
-        rt().block_on(self.greenlight_alby_client.connect_peer(req))
+        self.call(
+            "connect_peer",
+            Some(&req),
+            self.greenlight_alby_client.connect_peer(req.clone()),
+        )
     }
 
     pub fn fund_channel(&self, req: FundChannelRequest) -> Result<FundChannelResponse> {
-        rt().block_on(self.greenlight_alby_client.fund_channel(req))
+        self.call(
+            "fund_channel",
+            Some(&req),
+            self.greenlight_alby_client.fund_channel(req.clone()),
+        )
     }
 
     pub fn new_address(&self, req: NewAddressRequest) -> Result<NewAddressResponse> {
-        rt().block_on(self.greenlight_alby_client.new_address(req))
+        self.call(
+            "new_address",
+            Some(&req),
+            self.greenlight_alby_client.new_address(req.clone()),
+        )
     }
 
     pub fn list_invoices(&self, req: ListInvoicesRequest) -> Result<ListInvoicesResponse> {
-        rt().block_on(self.greenlight_alby_client.list_invoices(req))
+        self.read("list_invoices", Some(&req), || {
+            self.greenlight_alby_client.list_invoices(req.clone())
+        })
     }
 
     pub fn list_payments(&self, req: ListPaymentsRequest) -> Result<ListPaymentsResponse> {
-        rt().block_on(self.greenlight_alby_client.list_payments(req))
+        self.read("list_payments", Some(&req), || {
+            self.greenlight_alby_client.list_payments(req.clone())
+        })
     }
 
     pub fn sign_message(&self, req: SignMessageRequest) -> Result<SignMessageResponse> {
-        rt().block_on(self.greenlight_alby_client.sign_message(req))
+        self.read("sign_message", Some(&req), || {
+            self.greenlight_alby_client.sign_message(req.clone())
+        })
     }
 
     pub fn withdraw(&self, req: WithdrawRequest) -> Result<WithdrawResponse> {
-        rt().block_on(self.greenlight_alby_client.withdraw(req))
+        self.call(
+            "withdraw",
+            Some(&req),
+            self.greenlight_alby_client.withdraw(req.clone()),
+        )
     }
 
     pub fn close(&self, req: CloseRequest) -> Result<CloseResponse> {
-        rt().block_on(self.greenlight_alby_client.close(req))
+        self.call(
+            "close",
+            Some(&req),
+            self.greenlight_alby_client.close(req.clone()),
+        )
+    }
+
+    pub fn get_log(&self, req: GetLogRequest) -> Result<GetLogResponse> {
+        self.read("get_log", Some(&req), || {
+            self.greenlight_alby_client.get_log(req.clone())
+        })
+    }
+
+    pub fn list_configs(&self, req: ListConfigsRequest) -> Result<ListConfigsResponse> {
+        self.read("list_configs", Some(&req), || {
+            self.greenlight_alby_client.list_configs(req.clone())
+        })
+    }
+
+    pub fn set_config(&self, req: SetConfigRequest) -> Result<SetConfigResponse> {
+        self.call(
+            "set_config",
+            Some(&req),
+            self.greenlight_alby_client.set_config(req.clone()),
+        )
+    }
+
+    pub fn wait(&self, req: WaitRequest) -> Result<WaitResponse> {
+        self.read("wait", Some(&req), || {
+            self.greenlight_alby_client.wait(req.clone())
+        })
+    }
+
+    pub fn auto_clean_once(&self, req: AutoCleanOnceRequest) -> Result<AutoCleanOnceResponse> {
+        self.call(
+            "auto_clean_once",
+            Some(&req),
+            self.greenlight_alby_client.auto_clean_once(req.clone()),
+        )
+    }
+
+    pub fn auto_clean_status(
+        &self,
+        req: AutoCleanStatusRequest,
+    ) -> Result<AutoCleanStatusResponse> {
+        self.read("auto_clean_status", Some(&req), || {
+            self.greenlight_alby_client.auto_clean_status(req.clone())
+        })
+    }
+
+    pub fn wait_any_invoice(&self, req: WaitAnyInvoiceRequest) -> Result<WaitAnyInvoiceResponse> {
+        self.read("wait_any_invoice", Some(&req), || {
+            self.greenlight_alby_client.wait_any_invoice(req.clone())
+        })
+    }
+
+    pub fn pre_approve_invoice(
+        &self,
+        req: PreApproveInvoiceRequest,
+    ) -> Result<PreApproveInvoiceResponse> {
+        self.call(
+            "pre_approve_invoice",
+            Some(&req),
+            self.greenlight_alby_client.pre_approve_invoice(req.clone()),
+        )
+    }
+
+    pub fn pre_approve_keysend(
+        &self,
+        req: PreApproveKeysendRequest,
+    ) -> Result<PreApproveKeysendResponse> {
+        self.call(
+            "pre_approve_keysend",
+            Some(&req),
+            self.greenlight_alby_client.pre_approve_keysend(req.clone()),
+        )
+    }
+
+    pub fn trampoline_pay(&self, req: TrampolinePayRequest) -> Result<TrampolinePayResponse> {
+        self.call(
+            "trampoline_pay",
+            Some(&req),
+            self.greenlight_alby_client.trampoline_pay(req.clone()),
+        )
+    }
+
+    pub fn get_route(&self, req: GetRouteRequest) -> Result<GetRouteResponse> {
+        self.read("get_route", Some(&req), || {
+            self.greenlight_alby_client.get_route(req.clone())
+        })
+    }
+
+    pub fn send_pay(&self, req: SendPayRequest) -> Result<SendPayPart> {
+        self.call(
+            "send_pay",
+            Some(&req),
+            self.greenlight_alby_client.send_pay(req.clone()),
+        )
+    }
+
+    pub fn wait_send_pay(&self, req: WaitSendPayRequest) -> Result<SendPayPart> {
+        self.read("wait_send_pay", Some(&req), || {
+            self.greenlight_alby_client.wait_send_pay(req.clone())
+        })
+    }
+
+    pub fn subscribe_logs(
+        &self,
+        min_level: Option<GetLogLevel>,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        self.call(
+            "subscribe_logs",
+            None,
+            self.greenlight_alby_client
+                .subscribe_logs(min_level, config, listener),
+        )
+    }
+
+    pub fn subscribe_invoices(
+        &self,
+        lastpay_index: Option<u64>,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        self.call(
+            "subscribe_invoices",
+            None,
+            self.greenlight_alby_client
+                .subscribe_invoices(lastpay_index, config, listener),
+        )
+    }
+
+    pub fn subscribe_payments(
+        &self,
+        updated_index: Option<u64>,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        self.call(
+            "subscribe_payments",
+            None,
+            self.greenlight_alby_client
+                .subscribe_payments(updated_index, config, listener),
+        )
+    }
+
+    pub fn subscribe_channels(
+        &self,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        self.call(
+            "subscribe_channels",
+            None,
+            self.greenlight_alby_client
+                .subscribe_channels(config, listener),
+        )
+    }
+
+    pub fn subscribe_peers(
+        &self,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        self.call(
+            "subscribe_peers",
+            None,
+            self.greenlight_alby_client
+                .subscribe_peers(config, listener),
+        )
+    }
+
+    pub fn subscribe_forwards(
+        &self,
+        created_index: Option<u64>,
+        updated_index: Option<u64>,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        self.call(
+            "subscribe_forwards",
+            None,
+            self.greenlight_alby_client.subscribe_forwards(
+                created_index,
+                updated_index,
+                config,
+                listener,
+            ),
+        )
+    }
+
+    pub fn subscribe_blocks(
+        &self,
+        last_height: Option<u32>,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        self.call(
+            "subscribe_blocks",
+            None,
+            self.greenlight_alby_client
+                .subscribe_blocks(last_height, config, listener),
+        )
+    }
+
+    pub fn send_custom_msg(&self, req: SendCustomMsgRequest) -> Result<SendCustomMsgResponse> {
+        self.call(
+            "send_custom_msg",
+            Some(&req),
+            self.greenlight_alby_client.send_custom_msg(req.clone()),
+        )
+    }
+
+    pub fn subscribe_custom_messages(
+        &self,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        self.call(
+            "subscribe_custom_messages",
+            None,
+            self.greenlight_alby_client
+                .subscribe_custom_messages(config, listener),
+        )
+    }
+
+    pub fn subscribe_created_invoices(
+        &self,
+        created_index: Option<u64>,
+        config: Option<EventStreamConfig>,
+        listener: Box<dyn EventListener>,
+    ) -> Result<Arc<EventSubscription>> {
+        self.call(
+            "subscribe_created_invoices",
+            None,
+            self.greenlight_alby_client
+                .subscribe_created_invoices(created_index, config, listener),
+        )
+    }
+
+    pub fn list_all_transactions(
+        &self,
+        req: ListAllTransactionsRequest,
+    ) -> Result<ListAllTransactionsResponse> {
+        self.read("list_all_transactions", Some(&req), || {
+            self.greenlight_alby_client
+                .list_all_transactions(req.clone())
+        })
+    }
+
+    // Returns a pager that fetches matching invoices page_size at a time.
+    pub fn list_invoices_pager(
+        &self,
+        req: ListInvoicesRequest,
+        page_size: u32,
+    ) -> Arc<BlockingListInvoicesPager> {
+        Arc::new(BlockingListInvoicesPager {
+            client: self.share(),
+            pager: ListInvoicesPager::new(self.greenlight_alby_client.clone(), req, page_size),
+        })
+    }
+
+    pub fn list_payments_pager(
+        &self,
+        req: ListPaymentsRequest,
+        page_size: u32,
+    ) -> Arc<BlockingListPaymentsPager> {
+        Arc::new(BlockingListPaymentsPager {
+            client: self.share(),
+            pager: ListPaymentsPager::new(self.greenlight_alby_client.clone(), req, page_size),
+        })
+    }
+
+    // Runs the requests concurrently on the same connection and returns the
+    // responses in the same order, so a screen that needs several of them
+    // only crosses the FFI boundary once. Each request is retried and
+    // reconnected on its own, and one that still fails is returned as an
+    // Error entry without losing the other responses.
+    pub fn batch(&self, requests: Vec<BatchRequest>) -> Result<Vec<BatchResponse>> {
+        let retry_policy = self.retry_policy.lock().unwrap().clone();
+        let responses = requests.iter().map(|request| {
+            let retry_policy = retry_policy.clone();
+            async move {
+                BatchResponse::from(
+                    self.retry_read(retry_policy, || {
+                        self.greenlight_alby_client.batch_request(request.clone())
+                    })
+                    .await,
+                )
+            }
+        });
+        self.run("batch", Some(&requests), async {
+            Ok(join_all(responses).await)
+        })
+    }
+
+    pub fn rotate_credentials(&self) -> Result<GreenlightCredentials> {
+        self.call(
+            "rotate_credentials",
+            None,
+            self.greenlight_alby_client.rotate_credentials(),
+        )
+    }
+
+    pub fn node_status(&self) -> Result<NodeStatus> {
+        self.read("node_status", None, || {
+            self.greenlight_alby_client.node_status()
+        })
+    }
+
+    // Not retried or reconnected, so a probe reports the current state
+    // instead of waiting for the node to come back.
+    pub fn health_check(&self) -> Result<HealthCheckResponse> {
+        self.run(
+            "health_check",
+            None,
+            self.greenlight_alby_client.health_check(),
+        )
+    }
+
+    pub fn version(&self) -> Result<VersionInfo> {
+        self.read("version", None, || self.greenlight_alby_client.version())
+    }
+
+    pub fn schedule(&self) -> Result<NodeStatus> {
+        self.call("schedule", None, self.greenlight_alby_client.schedule())
+    }
+
+    // Not reconnected: the connection drops as the node stops, and
+    // reconnecting would schedule it again.
+    pub fn stop_node(&self) -> Result<()> {
+        self.run("stop_node", None, self.greenlight_alby_client.stop_node())
+    }
+
+    pub fn static_backup(&self) -> Result<StaticBackupResponse> {
+        self.read("static_backup", None, || {
+            self.greenlight_alby_client.static_backup()
+        })
+    }
+
+    pub fn emergency_recover(&self) -> Result<EmergencyRecoverResponse> {
+        self.call(
+            "emergency_recover",
+            None,
+            self.greenlight_alby_client.emergency_recover(),
+        )
+    }
+
+    pub fn recover_channel(&self, req: RecoverChannelRequest) -> Result<RecoverChannelResponse> {
+        self.call(
+            "recover_channel",
+            Some(&req),
+            self.greenlight_alby_client.recover_channel(req.clone()),
+        )
+    }
+
+    pub fn upgrade_wallet(&self, req: UpgradeWalletRequest) -> Result<UpgradeWalletResponse> {
+        self.call(
+            "upgrade_wallet",
+            Some(&req),
+            self.greenlight_alby_client.upgrade_wallet(req.clone()),
+        )
     }
 }
 
-pub fn recover(mnemonic: String) -> Result<GreenlightCredentials> {
-    rt().block_on(greenlight_alby_client::recover(mnemonic))
+pub fn recover(
+    mnemonic: String,
+    network: Network,
+    scheduler_config: Option<SchedulerConfig>,
+) -> Result<GreenlightCredentials> {
+    rt().block_on(greenlight_alby_client::recover(
+        mnemonic,
+        network,
+        scheduler_config,
+    ))
 }
 
-pub fn register(mnemonic: String, invite_code: String) -> Result<GreenlightCredentials> {
-    rt().block_on(greenlight_alby_client::register(mnemonic, invite_code))
+pub fn register(
+    mnemonic: String,
+    invite_code: Option<String>,
+    partner_credentials: Option<PartnerCredentials>,
+    network: Network,
+    scheduler_config: Option<SchedulerConfig>,
+) -> Result<GreenlightCredentials> {
+    rt().block_on(greenlight_alby_client::register(
+        mnemonic,
+        invite_code,
+        partner_credentials,
+        network,
+        scheduler_config,
+    ))
 }
 
-pub fn new_blocking_greenlight_alby_client(
+pub fn upgrade_legacy_credentials(
     mnemonic: String,
+    legacy_credentials: LegacyCredentials,
+    network: Network,
+    scheduler_config: Option<SchedulerConfig>,
+) -> Result<GreenlightCredentials> {
+    rt().block_on(greenlight_alby_client::upgrade_legacy_credentials(
+        mnemonic,
+        legacy_credentials,
+        network,
+        scheduler_config,
+    ))
+}
+
+pub fn get_node_id_from_mnemonic(mnemonic: String, network: Network) -> Result<String> {
+    greenlight_alby_client::get_node_id_from_mnemonic(mnemonic, network)
+}
+
+pub fn inspect_credentials(credentials: GreenlightCredentials) -> Result<CredentialsInfo> {
+    greenlight_alby_client::inspect_credentials(credentials)
+}
+
+pub fn version() -> VersionInfo {
+    version::version()
+}
+
+pub fn funds_summary(funds: ListFundsResponse) -> FundsSummary {
+    funds.summary()
+}
+
+pub fn msat_from_sat(sat: u64) -> Msat {
+    Msat::from_sat(sat)
+}
+
+pub fn msat_to_sat(amount: Msat) -> u64 {
+    amount.sat()
+}
+
+pub fn msat_to_btc(amount: Msat) -> f64 {
+    amount.btc()
+}
+
+pub fn msat_format(amount: Msat) -> String {
+    amount.to_string()
+}
+
+pub fn encrypt_credentials(
+    credentials: GreenlightCredentials,
+    passphrase: String,
+) -> Result<String> {
+    credentials::encrypt_credentials(credentials, passphrase)
+}
+
+pub fn decrypt_credentials(
+    encrypted_credentials: String,
+    passphrase: String,
+) -> Result<GreenlightCredentials> {
+    credentials::decrypt_credentials(encrypted_credentials, passphrase)
+}
+
+pub fn new_blocking_greenlight_alby_client_from_seed(
+    seed: Vec<u8>,
     credentials: GreenlightCredentials,
+    network: Network,
+    scheduler_config: Option<SchedulerConfig>,
 ) -> Result<Arc<BlockingGreenlightAlbyClient>> {
     rt().block_on(async move {
-        let greenlight_alby_client = new_greenlight_alby_client(mnemonic, credentials).await?;
-        let blocking_greenlight_alby_client = Arc::new(BlockingGreenlightAlbyClient {
+        let greenlight_alby_client =
+            new_greenlight_alby_client_from_seed(seed, credentials, network, scheduler_config)
+                .await?;
+
+        Ok(Arc::new(BlockingGreenlightAlbyClient::new(
             greenlight_alby_client,
-        });
+        )))
+    })
+}
+
+pub fn new_blocking_greenlight_alby_client(
+    mnemonic: String,
+    credentials: GreenlightCredentials,
+    network: Network,
+    scheduler_config: Option<SchedulerConfig>,
+) -> Result<Arc<BlockingGreenlightAlbyClient>> {
+    rt().block_on(async move {
+        let greenlight_alby_client =
+            new_greenlight_alby_client(mnemonic, credentials, network, scheduler_config).await?;
+        let blocking_greenlight_alby_client =
+            Arc::new(BlockingGreenlightAlbyClient::new(greenlight_alby_client));
 
         Ok(blocking_greenlight_alby_client)
     })
 }
 
+// Options for new_blocking_greenlight_alby_client_with_config. New options
+// are added here as optional fields so existing callers keep working.
+pub struct ClientConfig {
+    pub mnemonic: String,
+    pub credentials: GreenlightCredentials,
+    pub network: Network,
+    pub scheduler_config: Option<SchedulerConfig>,
+    pub default_timeout_secs: Option<u32>,
+    pub reconnect_policy: Option<ReconnectPolicy>,
+    pub retry_policy: Option<RetryPolicy>,
+    pub keepalive_policy: Option<KeepalivePolicy>,
+    // Defaults to true. Set to false when the signer is run separately with
+    // start_signer.
+    pub run_signer: Option<bool>,
+}
+
+pub fn new_blocking_greenlight_alby_client_with_config(
+    config: ClientConfig,
+) -> Result<Arc<BlockingGreenlightAlbyClient>> {
+    let greenlight_alby_client = rt().block_on(open_greenlight_alby_client(
+        config.mnemonic,
+        config.credentials,
+        config.network,
+        config.scheduler_config,
+        config.run_signer.unwrap_or(true),
+    ))?;
+    let client = Arc::new(BlockingGreenlightAlbyClient::new(greenlight_alby_client));
+    client.set_default_timeout(config.default_timeout_secs);
+    client.set_reconnect_policy(config.reconnect_policy);
+    client.set_retry_policy(config.retry_policy);
+    client.set_keepalive_policy(config.keepalive_policy);
+
+    Ok(client)
+}
+
+// Pages are read like any other call, so they are timed, retried and seen
+// by interceptors. The pager's cursor only moves past what was read, so a
+// failed page can be retried without skipping anything.
+pub struct BlockingListInvoicesPager {
+    client: BlockingGreenlightAlbyClient,
+    pager: ListInvoicesPager,
+}
+
+impl BlockingListInvoicesPager {
+    pub fn next_page(&self) -> Result<Vec<ListInvoicesInvoice>> {
+        self.client
+            .read("list_invoices_page", None, || self.pager.next_page())
+    }
+}
+
+pub struct BlockingListPaymentsPager {
+    client: BlockingGreenlightAlbyClient,
+    pager: ListPaymentsPager,
+}
+
+impl BlockingListPaymentsPager {
+    pub fn next_page(&self) -> Result<Vec<ListPaymentsPayment>> {
+        self.client
+            .read("list_payments_page", None, || self.pager.next_page())
+    }
+}
+
+pub struct BlockingSignerHandle {
+    signer_handle: Arc<SignerHandle>,
+}
+
+impl BlockingSignerHandle {
+    pub fn stop(&self) {
+        rt().block_on(self.signer_handle.stop())
+    }
+
+    pub fn is_running(&self) -> bool {
+        self.signer_handle.is_running()
+    }
+}
+
+pub fn start_signer(
+    mnemonic: String,
+    credentials: GreenlightCredentials,
+    network: Network,
+) -> Result<Arc<BlockingSignerHandle>> {
+    rt().block_on(async move {
+        let signer_handle = signer::start_signer(mnemonic, credentials, network).await?;
+
+        Ok(Arc::new(BlockingSignerHandle { signer_handle }))
+    })
+}
+
+pub fn set_log_listener(listener: Box<dyn LogListener>, min_level: LogLevel) {
+    logger::set_log_listener(listener, min_level)
+}
+
 fn rt() -> &'static tokio::runtime::Runtime {
     &RT
 }
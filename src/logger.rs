@@ -0,0 +1,90 @@
+use std::sync::RwLock;
+
+use log::{Level, LevelFilter, Log, Metadata, Record};
+use once_cell::sync::Lazy;
+
+#[derive(Copy, Clone, Debug, PartialEq, Eq)]
+pub enum LogLevel {
+    Error,
+    Warn,
+    Info,
+    Debug,
+    Trace,
+}
+
+impl From<Level> for LogLevel {
+    fn from(level: Level) -> Self {
+        match level {
+            Level::Error => LogLevel::Error,
+            Level::Warn => LogLevel::Warn,
+            Level::Info => LogLevel::Info,
+            Level::Debug => LogLevel::Debug,
+            Level::Trace => LogLevel::Trace,
+        }
+    }
+}
+
+impl From<LogLevel> for LevelFilter {
+    fn from(level: LogLevel) -> Self {
+        match level {
+            LogLevel::Error => LevelFilter::Error,
+            LogLevel::Warn => LevelFilter::Warn,
+            LogLevel::Info => LevelFilter::Info,
+            LogLevel::Debug => LevelFilter::Debug,
+            LogLevel::Trace => LevelFilter::Trace,
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct LogEntry {
+    pub level: LogLevel,
+    pub target: String,
+    pub message: String,
+}
+
+pub trait LogListener: Send + Sync {
+    fn log(&self, entry: LogEntry);
+}
+
+// The log crate only allows a single logger per process, so it is installed
+// once and forwards records to whichever listener was set last.
+struct ListenerLogger {
+    listener: RwLock<Option<Box<dyn LogListener>>>,
+}
+
+static LOGGER: Lazy<ListenerLogger> = Lazy::new(|| ListenerLogger {
+    listener: RwLock::new(None),
+});
+
+impl Log for ListenerLogger {
+    fn enabled(&self, metadata: &Metadata) -> bool {
+        metadata.level() <= log::max_level()
+    }
+
+    fn log(&self, record: &Record) {
+        if !self.enabled(record.metadata()) {
+            return;
+        }
+
+        if let Some(listener) = self.listener.read().unwrap().as_ref() {
+            listener.log(LogEntry {
+                level: record.level().into(),
+                target: record.target().to_string(),
+                message: record.args().to_string(),
+            });
+        }
+    }
+
+    fn flush(&self) {}
+}
+
+// Delivers log records from the SDK and gl-client at or above min_level to
+// the listener, replacing any listener set before.
+pub(crate) fn set_log_listener(listener: Box<dyn LogListener>, min_level: LogLevel) {
+    // Fails if the logger was installed by an earlier call, in which case
+    // only the listener needs replacing.
+    let _ = log::set_logger(&*LOGGER);
+    log::set_max_level(min_level.into());
+    *LOGGER.listener.write().unwrap() = Some(listener);
+}
@@ -0,0 +1,542 @@
+use std::cmp::Reverse;
+use std::sync::Arc;
+
+use anyhow::Context;
+use gl_client::pb::cln;
+use tokio::sync::Mutex;
+
+use crate::greenlight_alby_client::{
+    GreenlightAlbyClient, ListInvoicesIndex, ListInvoicesInvoice, ListInvoicesRequest,
+    ListPaymentsIndex, ListPaymentsPayment, ListPaymentsRequest, ListPaymentsResponse, Result,
+    SdkError, WaitIndexname, WaitRequest, WaitSubsystem,
+};
+
+// Pulls invoices a page at a time by created index, so nodes with a large
+// history never have to return them all in one response. Pages are oldest
+// first and an empty page means there are no more invoices.
+pub struct ListInvoicesPager {
+    client: Arc<GreenlightAlbyClient>,
+    req: ListInvoicesRequest,
+    page_size: u32,
+    next_index: Mutex<Option<u64>>,
+}
+
+impl ListInvoicesPager {
+    pub(crate) fn new(
+        client: Arc<GreenlightAlbyClient>,
+        req: ListInvoicesRequest,
+        page_size: u32,
+    ) -> Self {
+        let start = req.start.unwrap_or(0);
+        ListInvoicesPager {
+            client,
+            req,
+            page_size: page_size.max(1),
+            next_index: Mutex::new(Some(start)),
+        }
+    }
+
+    pub async fn next_page(&self) -> Result<Vec<ListInvoicesInvoice>> {
+        let mut next_index = self.next_index.lock().await;
+        while let Some(start) = *next_index {
+            // The status filters are applied to each page afterwards, so a
+            // short page reliably means the end was reached.
+            let page = self
+                .client
+                .list_invoices(ListInvoicesRequest {
+                    index: Some(ListInvoicesIndex::Created),
+                    start: Some(start),
+                    limit: Some(self.page_size),
+                    status: None,
+                    exclude_expired: None,
+                    ..self.req.clone()
+                })
+                .await?
+                .invoices;
+
+            *next_index = match page.last().and_then(|i| i.created_index) {
+                Some(last) if page.len() as u32 == self.page_size => Some(last + 1),
+                _ => None,
+            };
+
+            let invoices: Vec<ListInvoicesInvoice> =
+                page.into_iter().filter(|i| self.req.matches(i)).collect();
+            if !invoices.is_empty() {
+                return Ok(invoices);
+            }
+        }
+        Ok(Vec::new())
+    }
+}
+
+// Like ListInvoicesPager, for payments. Pages are fetched from listsendpays,
+// so page_size counts payment parts; a payment whose parts straddle the end
+// of a page is returned with the next page instead.
+pub struct ListPaymentsPager {
+    client: Arc<GreenlightAlbyClient>,
+    req: ListPaymentsRequest,
+    page_size: u32,
+    cursor: Mutex<PartsCursor>,
+}
+
+impl ListPaymentsPager {
+    pub(crate) fn new(
+        client: Arc<GreenlightAlbyClient>,
+        req: ListPaymentsRequest,
+        page_size: u32,
+    ) -> Self {
+        let start = req.start.unwrap_or(0);
+        ListPaymentsPager {
+            client,
+            req,
+            page_size: page_size.max(1),
+            cursor: Mutex::new(PartsCursor {
+                next_index: Some(start),
+                carried: Vec::new(),
+            }),
+        }
+    }
+
+    pub async fn next_page(&self) -> Result<Vec<ListPaymentsPayment>> {
+        let mut cursor = self.cursor.lock().await;
+        while let Some(start) = cursor.next_index {
+            let page = self
+                .client
+                .list_send_pays(
+                    &self.req,
+                    Some(ListPaymentsIndex::Created),
+                    Some(start),
+                    Some(self.page_size),
+                )
+                .await?;
+
+            let payments = group_payments(cursor.advance(page, self.page_size), &self.req);
+            if !payments.is_empty() {
+                return Ok(payments);
+            }
+        }
+        Ok(Vec::new())
+    }
+}
+
+// Where ListPaymentsPager continues from. Parts of the payment at the end of
+// a page are carried over to the next one, so each part is read once and a
+// payment is only grouped once its following parts were fetched.
+struct PartsCursor {
+    next_index: Option<u64>,
+    carried: Vec<cln::ListsendpaysPayments>,
+}
+
+impl PartsCursor {
+    // Moves past a page read from next_index and returns the parts that can
+    // be grouped into payments.
+    fn advance(
+        &mut self,
+        page: Vec<cln::ListsendpaysPayments>,
+        page_size: u32,
+    ) -> Vec<cln::ListsendpaysPayments> {
+        let next = page.iter().filter_map(|p| p.created_index).max();
+        let last_page = (page.len() as u32) < page_size || next.is_none();
+
+        let mut parts = std::mem::take(&mut self.carried);
+        parts.extend(page);
+        if last_page {
+            self.next_index = None;
+            return parts;
+        }
+
+        self.next_index = next.map(|i| i + 1);
+        let (rest, carried) = split_boundary_payment(parts, true);
+        self.carried = carried;
+        rest
+    }
+}
+
+fn part_index(part: &cln::ListsendpaysPayments, index: ListPaymentsIndex) -> Option<u64> {
+    match index {
+        ListPaymentsIndex::Created => part.created_index,
+        ListPaymentsIndex::Updated => part.updated_index,
+    }
+}
+
+fn payment_index(payment: &ListPaymentsPayment, index: ListPaymentsIndex) -> Option<u64> {
+    match index {
+        ListPaymentsIndex::Created => payment.created_index,
+        ListPaymentsIndex::Updated => payment.updated_index,
+    }
+}
+
+// Splits off the parts of the payment at one end of a page, since more of
+// its parts may be on the adjacent page. Returns the remaining parts and the
+// held back ones.
+pub(crate) fn split_boundary_payment(
+    parts: Vec<cln::ListsendpaysPayments>,
+    at_end: bool,
+) -> (
+    Vec<cln::ListsendpaysPayments>,
+    Vec<cln::ListsendpaysPayments>,
+) {
+    let boundary = if at_end { parts.last() } else { parts.first() }
+        .map(|p| (p.payment_hash.clone(), p.groupid));
+    let (held_back, rest) = parts
+        .into_iter()
+        .partition(|p| Some((p.payment_hash.clone(), p.groupid)) == boundary);
+    (rest, held_back)
+}
+
+pub(crate) fn group_payments(
+    parts: Vec<cln::ListsendpaysPayments>,
+    req: &ListPaymentsRequest,
+) -> Vec<ListPaymentsPayment> {
+    ListPaymentsResponse::from(cln::ListsendpaysResponse { payments: parts })
+        .payments
+        .into_iter()
+        .filter(|p| req.matches(p))
+        .collect()
+}
+
+impl GreenlightAlbyClient {
+    pub(crate) async fn list_send_pays(
+        &self,
+        req: &ListPaymentsRequest,
+        index: Option<ListPaymentsIndex>,
+        start: Option<u64>,
+        limit: Option<u32>,
+    ) -> Result<Vec<cln::ListsendpaysPayments>> {
+        Ok(self
+            .node()
+            .list_send_pays(cln::ListsendpaysRequest::try_from(ListPaymentsRequest {
+                index,
+                start,
+                limit,
+                ..req.clone()
+            })?)
+            .await
+            .context("failed to list payments")
+            .map_err(SdkError::greenlight_api)?
+            .into_inner()
+            .payments)
+    }
+
+    pub(crate) async fn list_payments_all(
+        &self,
+        req: &ListPaymentsRequest,
+    ) -> Result<Vec<ListPaymentsPayment>> {
+        let parts = self.list_send_pays(req, req.index, req.start, None).await?;
+        Ok(group_payments(parts, req))
+    }
+
+    // Walks forward from req.start until `limit` matching payments were
+    // found. The payment at the end of each page is carried over to the next
+    // one, so it is only grouped once all of its parts were fetched.
+    pub(crate) async fn list_payments_forward(
+        &self,
+        req: &ListPaymentsRequest,
+        limit: u32,
+    ) -> Result<Vec<ListPaymentsPayment>> {
+        if limit == 0 {
+            return Ok(Vec::new());
+        }
+
+        let index = req.index.unwrap_or(ListPaymentsIndex::Created);
+        let mut start = req.start.unwrap_or(0);
+        let mut carried = Vec::new();
+        let mut payments = Vec::new();
+        loop {
+            let page = self
+                .list_send_pays(req, Some(index), Some(start), Some(limit))
+                .await?;
+            let next = page.iter().filter_map(|p| part_index(p, index)).max();
+            // Creation times only grow with the created index, so nothing
+            // after a part created past created_to can match.
+            let past_range = matches!(index, ListPaymentsIndex::Created)
+                && req
+                    .created_to
+                    .map_or(false, |to| page.iter().any(|p| p.created_at > to));
+            let last_page = (page.len() as u32) < limit || next.is_none() || past_range;
+
+            let mut parts = std::mem::take(&mut carried);
+            parts.extend(page);
+            if !last_page {
+                (parts, carried) = split_boundary_payment(parts, true);
+            }
+            payments.extend(group_payments(parts, req));
+
+            match next {
+                Some(next) if !last_page && payments.len() < limit as usize => start = next + 1,
+                _ => break,
+            }
+        }
+
+        payments.sort_by_key(|p| payment_index(p, index));
+        payments.truncate(limit as usize);
+        Ok(payments)
+    }
+
+    // Walks backwards from req.start, or from the newest payment, until
+    // `limit` matching payments were found, so the most recent payments can
+    // be listed without reading the whole history. The payment at the start
+    // of each page is carried over to the next, older one.
+    pub(crate) async fn list_payments_backward(
+        &self,
+        req: &ListPaymentsRequest,
+        limit: u32,
+    ) -> Result<Vec<ListPaymentsPayment>> {
+        if limit == 0 {
+            return Ok(Vec::new());
+        }
+
+        let index = req.index.unwrap_or(ListPaymentsIndex::Created);
+        let mut end = match req.start {
+            Some(start) => start,
+            None => {
+                let indexname = match index {
+                    ListPaymentsIndex::Created => WaitIndexname::Created,
+                    ListPaymentsIndex::Updated => WaitIndexname::Updated,
+                };
+                self.current_index(WaitSubsystem::Sendpays, indexname)
+                    .await?
+            }
+        };
+        let mut carried = Vec::new();
+        let mut payments = Vec::new();
+        // Indexes start at 1.
+        while end > 0 {
+            let start = end.saturating_sub(u64::from(limit) - 1).max(1);
+            let mut page = self
+                .list_send_pays(
+                    req,
+                    Some(index),
+                    Some(start),
+                    Some((end - start + 1) as u32),
+                )
+                .await?;
+            // Parts past the end were already read, or are newer than the
+            // requested start, when the node cleaned up older ones.
+            page.retain(|p| part_index(p, index).map_or(false, |i| i <= end));
+
+            let before_range = matches!(index, ListPaymentsIndex::Created)
+                && req
+                    .created_from
+                    .map_or(false, |from| page.iter().any(|p| p.created_at < from));
+            let first_page = start == 1 || before_range;
+
+            let mut parts = page;
+            parts.append(&mut carried);
+            if !first_page {
+                (parts, carried) = split_boundary_payment(parts, false);
+            }
+            payments.extend(group_payments(parts, req));
+
+            if first_page || payments.len() >= limit as usize {
+                break;
+            }
+            end = start - 1;
+        }
+
+        payments.sort_by_key(|p| Reverse(payment_index(p, index)));
+        payments.truncate(limit as usize);
+        Ok(payments)
+    }
+
+    pub(crate) async fn current_index(
+        &self,
+        subsystem: WaitSubsystem,
+        indexname: WaitIndexname,
+    ) -> Result<u64> {
+        // Waiting for a value that was already reached returns immediately
+        // with the current one.
+        let response = self
+            .wait(WaitRequest {
+                subsystem,
+                indexname,
+                nextvalue: 0,
+            })
+            .await?;
+        Ok(match indexname {
+            WaitIndexname::Created => response.created,
+            WaitIndexname::Updated => response.updated,
+            WaitIndexname::Deleted => response.deleted,
+        }
+        .unwrap_or(0))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use cln::listsendpays_payments::ListsendpaysPaymentsStatus;
+
+    use super::*;
+    use crate::greenlight_alby_client::ListPaymentsStatus;
+
+    fn part(
+        hash: u8,
+        groupid: u64,
+        status: ListsendpaysPaymentsStatus,
+        index: u64,
+    ) -> cln::ListsendpaysPayments {
+        cln::ListsendpaysPayments {
+            payment_hash: vec![hash],
+            groupid,
+            status: status as i32,
+            created_index: Some(index),
+            updated_index: Some(index),
+            created_at: index * 10,
+            ..Default::default()
+        }
+    }
+
+    fn request(status: Option<ListPaymentsStatus>) -> ListPaymentsRequest {
+        ListPaymentsRequest {
+            bolt11: None,
+            payment_hash: None,
+            status,
+            index: None,
+            start: None,
+            limit: None,
+            created_from: None,
+            created_to: None,
+            sort_direction: None,
+        }
+    }
+
+    fn hashes(payments: &[ListPaymentsPayment]) -> Vec<&str> {
+        payments.iter().map(|p| p.payment_hash.as_str()).collect()
+    }
+
+    fn indexes(parts: &[cln::ListsendpaysPayments]) -> Vec<u64> {
+        parts.iter().filter_map(|p| p.created_index).collect()
+    }
+
+    #[test]
+    fn splits_payment_at_end_of_page() {
+        let parts = vec![
+            part(1, 1, ListsendpaysPaymentsStatus::Complete, 1),
+            part(2, 1, ListsendpaysPaymentsStatus::Failed, 2),
+            part(1, 2, ListsendpaysPaymentsStatus::Pending, 3),
+            part(2, 1, ListsendpaysPaymentsStatus::Complete, 4),
+        ];
+        let (rest, held_back) = split_boundary_payment(parts, true);
+        assert_eq!(indexes(&rest), vec![1, 3]);
+        assert_eq!(indexes(&held_back), vec![2, 4]);
+    }
+
+    #[test]
+    fn splits_payment_at_start_of_page() {
+        let parts = vec![
+            part(1, 1, ListsendpaysPaymentsStatus::Complete, 1),
+            part(2, 1, ListsendpaysPaymentsStatus::Complete, 2),
+            part(1, 1, ListsendpaysPaymentsStatus::Complete, 3),
+        ];
+        let (rest, held_back) = split_boundary_payment(parts, false);
+        assert_eq!(indexes(&rest), vec![2]);
+        assert_eq!(indexes(&held_back), vec![1, 3]);
+
+        let (rest, held_back) = split_boundary_payment(Vec::new(), false);
+        assert!(rest.is_empty() && held_back.is_empty());
+    }
+
+    #[test]
+    fn groups_parts_into_payments() {
+        let parts = vec![
+            part(1, 1, ListsendpaysPaymentsStatus::Failed, 1),
+            part(1, 2, ListsendpaysPaymentsStatus::Failed, 2),
+            part(1, 2, ListsendpaysPaymentsStatus::Complete, 3),
+            part(2, 1, ListsendpaysPaymentsStatus::Pending, 4),
+        ];
+        let payments = group_payments(parts, &request(None));
+        let summary: Vec<(Option<u64>, ListPaymentsStatus, Option<u64>)> = payments
+            .iter()
+            .map(|p| (p.created_index, p.status, p.number_of_parts))
+            .collect();
+        assert_eq!(
+            summary,
+            vec![
+                (Some(1), ListPaymentsStatus::Failed, None),
+                (Some(2), ListPaymentsStatus::Complete, Some(1)),
+                (Some(4), ListPaymentsStatus::Pending, None),
+            ]
+        );
+        assert_eq!(payments[1].updated_index, Some(3));
+    }
+
+    #[test]
+    fn filters_grouped_payments() {
+        // The failed first part doesn't hide the completed payment.
+        let parts = vec![
+            part(1, 1, ListsendpaysPaymentsStatus::Failed, 1),
+            part(1, 1, ListsendpaysPaymentsStatus::Complete, 2),
+            part(2, 1, ListsendpaysPaymentsStatus::Failed, 3),
+            part(3, 1, ListsendpaysPaymentsStatus::Complete, 4),
+        ];
+
+        let complete = group_payments(parts.clone(), &request(Some(ListPaymentsStatus::Complete)));
+        assert_eq!(
+            complete.iter().map(|p| p.created_index).collect::<Vec<_>>(),
+            vec![Some(1), Some(4)]
+        );
+
+        let req = ListPaymentsRequest {
+            created_from: Some(20),
+            created_to: Some(30),
+            ..request(None)
+        };
+        let in_range = group_payments(parts, &req);
+        assert_eq!(
+            in_range.iter().map(|p| p.created_index).collect::<Vec<_>>(),
+            vec![Some(3)]
+        );
+    }
+
+    #[test]
+    fn pages_interleaved_payments_once() {
+        // Parts of payment 1 straddle the end of the first page, with a part
+        // of payment 2 in between.
+        let parts = vec![
+            part(1, 1, ListsendpaysPaymentsStatus::Failed, 1),
+            part(2, 1, ListsendpaysPaymentsStatus::Complete, 2),
+            part(1, 1, ListsendpaysPaymentsStatus::Complete, 3),
+            part(3, 1, ListsendpaysPaymentsStatus::Pending, 4),
+        ];
+        let mut cursor = PartsCursor {
+            next_index: Some(1),
+            carried: Vec::new(),
+        };
+        let mut pages = Vec::new();
+        while let Some(start) = cursor.next_index {
+            let page: Vec<cln::ListsendpaysPayments> = parts
+                .iter()
+                .filter(|p| p.created_index >= Some(start))
+                .take(3)
+                .cloned()
+                .collect();
+            pages.push(group_payments(cursor.advance(page, 3), &request(None)));
+        }
+
+        assert_eq!(pages.len(), 2);
+        assert_eq!(hashes(&pages[0]), vec!["02"]);
+        assert_eq!(hashes(&pages[1]), vec!["01", "03"]);
+        assert_eq!(pages[1][0].status, ListPaymentsStatus::Complete);
+        assert_eq!(pages[1][0].created_index, Some(1));
+        assert_eq!(pages[1][0].updated_index, Some(3));
+    }
+
+    #[test]
+    fn pages_payment_with_more_parts_than_page_size() {
+        let parts: Vec<cln::ListsendpaysPayments> = (1..=5)
+            .map(|i| part(1, 1, ListsendpaysPaymentsStatus::Complete, i))
+            .collect();
+        let mut cursor = PartsCursor {
+            next_index: Some(1),
+            carried: Vec::new(),
+        };
+
+        assert!(cursor.advance(parts[..2].to_vec(), 2).is_empty());
+        assert_eq!(cursor.next_index, Some(3));
+        assert!(cursor.advance(parts[2..4].to_vec(), 2).is_empty());
+        assert_eq!(cursor.next_index, Some(5));
+        let last = cursor.advance(parts[4..].to_vec(), 2);
+        assert_eq!(indexes(&last), vec![1, 2, 3, 4, 5]);
+        assert_eq!(cursor.next_index, None);
+    }
+}
@@ -0,0 +1,179 @@
+use std::future::Future;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::Mutex;
+use std::time::Duration;
+
+use rand::Rng;
+
+use crate::greenlight_alby_client::{GreenlightAlbyClient, Result, SdkError};
+use crate::retry::backoffs;
+
+const DEFAULT_MAX_ATTEMPTS: u32 = 5;
+const DEFAULT_INITIAL_BACKOFF_MS: u32 = 500;
+const DEFAULT_MAX_BACKOFF_MS: u32 = 30_000;
+
+#[derive(Clone, Debug)]
+pub struct ReconnectPolicy {
+    pub max_attempts: u32,
+    pub initial_backoff_ms: u32,
+    pub max_backoff_ms: u32,
+}
+
+impl Default for ReconnectPolicy {
+    fn default() -> Self {
+        ReconnectPolicy {
+            max_attempts: DEFAULT_MAX_ATTEMPTS,
+            initial_backoff_ms: DEFAULT_INITIAL_BACKOFF_MS,
+            max_backoff_ms: DEFAULT_MAX_BACKOFF_MS,
+        }
+    }
+}
+
+#[derive(Copy, Clone, Debug, PartialEq, Eq)]
+pub enum ConnectionState {
+    Connected,
+    Reconnecting,
+    Disconnected,
+}
+
+pub trait ConnectionStateListener: Send + Sync {
+    fn on_state_changed(&self, state: ConnectionState);
+}
+
+// Re-schedules the node when a call fails because the connection was lost,
+// e.g. after the node was descheduled. Concurrent failures share a single
+// reconnect: generation is bumped every time one succeeds, so calls that
+// failed on an older connection just retry.
+pub(crate) struct Reconnector {
+    policy: Mutex<Option<ReconnectPolicy>>,
+    listener: Mutex<Option<Box<dyn ConnectionStateListener>>>,
+    generation: AtomicU64,
+    reconnecting: tokio::sync::Mutex<()>,
+}
+
+impl Reconnector {
+    pub(crate) fn new() -> Self {
+        Reconnector {
+            policy: Mutex::new(None),
+            listener: Mutex::new(None),
+            generation: AtomicU64::new(0),
+            reconnecting: tokio::sync::Mutex::new(()),
+        }
+    }
+
+    pub(crate) fn set_policy(&self, policy: Option<ReconnectPolicy>) {
+        *self.policy.lock().unwrap() = policy;
+    }
+
+    pub(crate) fn set_listener(&self, listener: Box<dyn ConnectionStateListener>) {
+        *self.listener.lock().unwrap() = Some(listener);
+    }
+
+    // Runs a read-only call, repeating it once the node has been
+    // reconnected. A call that failed because the connection was lost may
+    // still have reached the node, so this is only safe for calls that
+    // don't change anything.
+    pub(crate) async fn read<T, F, Fut>(&self, client: &GreenlightAlbyClient, call: F) -> Result<T>
+    where
+        F: Fn() -> Fut,
+        Fut: Future<Output = Result<T>>,
+    {
+        let generation = self.generation.load(Ordering::SeqCst);
+        let result = call().await;
+        if let Err(SdkError::Connection { .. }) = result {
+            if self.reconnect(client, generation).await {
+                return call().await;
+            }
+        }
+        result
+    }
+
+    // Runs a call that must not be sent twice, like a payment. The node is
+    // still reconnected so the next call succeeds, but the original error is
+    // returned.
+    pub(crate) async fn call_once<T>(
+        &self,
+        client: &GreenlightAlbyClient,
+        call: impl Future<Output = Result<T>>,
+    ) -> Result<T> {
+        let generation = self.generation.load(Ordering::SeqCst);
+        let result = call.await;
+        if let Err(SdkError::Connection { .. }) = result {
+            self.reconnect(client, generation).await;
+        }
+        result
+    }
+
+    async fn reconnect(&self, client: &GreenlightAlbyClient, failed_generation: u64) -> bool {
+        let policy = match self.policy.lock().unwrap().clone() {
+            Some(policy) => policy,
+            None => return false,
+        };
+
+        let _reconnecting = self.reconnecting.lock().await;
+        if self.generation.load(Ordering::SeqCst) != failed_generation {
+            return true;
+        }
+
+        self.notify(ConnectionState::Reconnecting);
+        let mut backoffs = backoffs(policy.initial_backoff_ms, policy.max_backoff_ms);
+        for attempt in 1..=policy.max_attempts {
+            match client.reconnect().await {
+                Ok(()) => {
+                    self.generation.fetch_add(1, Ordering::SeqCst);
+                    self.notify(ConnectionState::Connected);
+                    return true;
+                }
+                Err(e) => log::warn!("Reconnect attempt {} failed: {}", attempt, e),
+            }
+
+            if attempt < policy.max_attempts {
+                let backoff_ms = backoffs.next().unwrap_or(policy.max_backoff_ms);
+                tokio::time::sleep(Duration::from_millis(jitter(backoff_ms).into())).await;
+            }
+        }
+
+        self.notify(ConnectionState::Disconnected);
+        false
+    }
+
+    fn notify(&self, state: ConnectionState) {
+        if let Some(listener) = self.listener.lock().unwrap().as_ref() {
+            listener.on_state_changed(state);
+        }
+    }
+}
+
+// Waits somewhere between half and all of the backoff, so clients that lost
+// their connection at the same time don't all reconnect at once.
+fn jitter(backoff_ms: u32) -> u32 {
+    rand::thread_rng().gen_range(backoff_ms / 2..=backoff_ms)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn jitter_stays_within_half_the_backoff() {
+        for backoff_ms in [0, 1, 500, 30_000, u32::MAX] {
+            for _ in 0..100 {
+                let jittered = jitter(backoff_ms);
+                assert!(jittered >= backoff_ms / 2 && jittered <= backoff_ms);
+            }
+        }
+    }
+
+    #[test]
+    fn default_backoff_reaches_max() {
+        let policy = ReconnectPolicy::default();
+        let delays: Vec<u32> = backoffs(policy.initial_backoff_ms, policy.max_backoff_ms)
+            .take(policy.max_attempts as usize)
+            .collect();
+        assert_eq!(delays, vec![500, 1_000, 2_000, 4_000, 8_000]);
+        assert_eq!(
+            backoffs(policy.initial_backoff_ms, policy.max_backoff_ms).nth(10),
+            Some(policy.max_backoff_ms)
+        );
+    }
+}
@@ -0,0 +1,168 @@
+use std::future::Future;
+use std::time::Duration;
+
+use crate::greenlight_alby_client::{Result, SdkError};
+
+const DEFAULT_MAX_ATTEMPTS: u32 = 3;
+const DEFAULT_INITIAL_BACKOFF_MS: u32 = 200;
+const DEFAULT_MAX_BACKOFF_MS: u32 = 2_000;
+
+#[derive(Copy, Clone, Debug, PartialEq, Eq)]
+pub enum RetryableError {
+    Connection,
+    Timeout,
+    GreenlightApi,
+}
+
+// Applied to read-only calls only, so retrying can't pay or open a channel
+// twice.
+#[derive(Clone, Debug)]
+pub struct RetryPolicy {
+    pub max_attempts: u32,
+    pub initial_backoff_ms: u32,
+    pub max_backoff_ms: u32,
+    pub retryable_errors: Vec<RetryableError>,
+}
+
+impl Default for RetryPolicy {
+    fn default() -> Self {
+        RetryPolicy {
+            max_attempts: DEFAULT_MAX_ATTEMPTS,
+            initial_backoff_ms: DEFAULT_INITIAL_BACKOFF_MS,
+            max_backoff_ms: DEFAULT_MAX_BACKOFF_MS,
+            retryable_errors: vec![RetryableError::Connection],
+        }
+    }
+}
+
+impl RetryPolicy {
+    fn is_retryable(&self, e: &SdkError) -> bool {
+        let class = match e {
+            SdkError::Connection { .. } => RetryableError::Connection,
+            SdkError::Timeout { .. } => RetryableError::Timeout,
+            SdkError::GreenlightApi { .. } => RetryableError::GreenlightApi,
+            _ => return false,
+        };
+        self.retryable_errors.contains(&class)
+    }
+}
+
+// Delays between attempts, starting at initial_ms and doubling up to max_ms.
+pub(crate) fn backoffs(initial_ms: u32, max_ms: u32) -> impl Iterator<Item = u32> {
+    std::iter::successors(Some(initial_ms), move |ms| {
+        Some(ms.saturating_mul(2).min(max_ms))
+    })
+}
+
+pub(crate) async fn retry<T, F, Fut>(policy: Option<RetryPolicy>, call: F) -> Result<T>
+where
+    F: Fn() -> Fut,
+    Fut: Future<Output = Result<T>>,
+{
+    let policy = match policy {
+        Some(policy) => policy,
+        None => return call().await,
+    };
+
+    let mut backoffs = backoffs(policy.initial_backoff_ms, policy.max_backoff_ms);
+    let mut attempt = 1;
+    loop {
+        match call().await {
+            Err(e) if attempt < policy.max_attempts && policy.is_retryable(&e) => {
+                log::debug!("Attempt {} failed, retrying: {}", attempt, e);
+                let backoff_ms = backoffs.next().unwrap_or(policy.max_backoff_ms);
+                tokio::time::sleep(Duration::from_millis(backoff_ms.into())).await;
+                attempt += 1;
+            }
+            result => return result,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::atomic::{AtomicU32, Ordering};
+    use std::time::Instant;
+
+    use super::*;
+
+    fn policy(max_attempts: u32) -> RetryPolicy {
+        RetryPolicy {
+            max_attempts,
+            initial_backoff_ms: 10,
+            max_backoff_ms: 15,
+            retryable_errors: vec![RetryableError::Connection],
+        }
+    }
+
+    fn connection_error() -> SdkError {
+        SdkError::Connection {
+            message: "unreachable".to_string(),
+        }
+    }
+
+    #[test]
+    fn backoff_doubles_up_to_max() {
+        let delays: Vec<u32> = backoffs(200, 1_000).take(5).collect();
+        assert_eq!(delays, vec![200, 400, 800, 1_000, 1_000]);
+        assert_eq!(backoffs(u32::MAX, u32::MAX).nth(1), Some(u32::MAX));
+    }
+
+    #[test]
+    fn only_configured_errors_are_retryable() {
+        let policy = policy(3);
+        assert!(policy.is_retryable(&connection_error()));
+        assert!(!policy.is_retryable(&SdkError::Timeout {
+            message: "too slow".to_string(),
+        }));
+        assert!(!policy.is_retryable(&SdkError::InvalidArgument {
+            message: "bad".to_string(),
+        }));
+    }
+
+    #[tokio::test]
+    async fn retries_retryable_errors_with_backoff() {
+        let attempts = AtomicU32::new(0);
+        let start = Instant::now();
+        let result: Result<()> = retry(Some(policy(3)), || async {
+            attempts.fetch_add(1, Ordering::SeqCst);
+            Err(connection_error())
+        })
+        .await;
+
+        assert!(matches!(result, Err(SdkError::Connection { .. })));
+        assert_eq!(attempts.load(Ordering::SeqCst), 3);
+        // Two waits: the initial backoff, then the doubled one capped at 15.
+        assert!(start.elapsed() >= Duration::from_millis(25));
+    }
+
+    #[tokio::test]
+    async fn stops_once_the_call_succeeds() {
+        let attempts = AtomicU32::new(0);
+        let result = retry(Some(policy(5)), || async {
+            match attempts.fetch_add(1, Ordering::SeqCst) {
+                0 => Err(connection_error()),
+                n => Ok(n),
+            }
+        })
+        .await;
+
+        assert_eq!(result.unwrap(), 1);
+        assert_eq!(attempts.load(Ordering::SeqCst), 2);
+    }
+
+    #[tokio::test]
+    async fn does_not_retry_other_errors() {
+        let attempts = AtomicU32::new(0);
+        let result: Result<()> = retry(Some(policy(5)), || async {
+            attempts.fetch_add(1, Ordering::SeqCst);
+            Err(SdkError::Timeout {
+                message: "too slow".to_string(),
+            })
+        })
+        .await;
+
+        assert!(matches!(result, Err(SdkError::Timeout { .. })));
+        assert_eq!(attempts.load(Ordering::SeqCst), 1);
+    }
+}
@@ -0,0 +1,81 @@
+use std::sync::Arc;
+use std::time::Duration;
+
+use anyhow::Context;
+use tokio::sync::mpsc::Sender;
+use tokio::task::JoinHandle;
+use tokio::time;
+
+use gl_client::signer::Signer;
+
+use crate::greenlight_alby_client::{
+    signer_secret, GreenlightCredentials, Network, Result, SdkError,
+};
+
+// Runs the signer loop in the background so the node can get signatures,
+// e.g. for incoming HTLCs, independently of any RPC client.
+pub struct SignerHandle {
+    shutdown: Sender<()>,
+    handle: JoinHandle<()>,
+}
+
+impl SignerHandle {
+    pub(crate) fn spawn(signer: Signer) -> Self {
+        let (tx, rx) = tokio::sync::mpsc::channel(1);
+        let handle = tokio::spawn(async move {
+            log::info!("Run forever started");
+            if let Err(e) = signer.run_forever(rx).await {
+                log::error!("Run forever error: {:?}", e);
+            }
+            log::info!("Run forever finished");
+        });
+
+        SignerHandle {
+            shutdown: tx,
+            handle,
+        }
+    }
+
+    pub async fn stop(&self) {
+        log::info!("Sending shutdown message");
+        // The signer may already have stopped on its own.
+        let _ = self.shutdown.send(()).await;
+
+        let mut tries = 0;
+        let max_tries = 2;
+        while !self.handle.is_finished() && tries < max_tries {
+            log::info!("Waiting for signer to stop...");
+            time::sleep(Duration::from_millis(1000)).await;
+            tries += 1;
+        }
+        if tries == max_tries {
+            log::warn!("Shutdown failed, aborting handle");
+            self.handle.abort();
+            time::sleep(Duration::from_millis(1000)).await;
+        }
+    }
+
+    pub fn is_running(&self) -> bool {
+        !self.handle.is_finished()
+    }
+}
+
+pub async fn start_signer(
+    mnemonic: String,
+    credentials: GreenlightCredentials,
+    network: Network,
+) -> Result<Arc<SignerHandle>> {
+    let cred_bytes = hex::decode(&credentials.gl_creds)
+        .context("failed to decode credentials")
+        .map_err(SdkError::invalid_arg)?;
+
+    let creds = gl_client::credentials::Device::from_bytes(&cred_bytes);
+
+    let secret = signer_secret(mnemonic)?;
+
+    let signer = Signer::new(secret.to_vec(), network.into(), creds)
+        .context("failed to create signer")
+        .map_err(SdkError::greenlight_api)?;
+
+    Ok(Arc::new(SignerHandle::spawn(signer)))
+}
@@ -0,0 +1,344 @@
+use std::cmp::Reverse;
+use std::collections::HashMap;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use anyhow::Context;
+use gl_client::pb::cln;
+
+use crate::amount::Msat;
+use crate::greenlight_alby_client::{
+    GreenlightAlbyClient, ListFundsRequest, ListInvoicesIndex, ListInvoicesInvoice,
+    ListInvoicesRequest, ListInvoicesStatus, ListPaymentsRequest, ListPaymentsStatus, Result,
+    SdkError, SortDirection, WaitIndexname, WaitSubsystem,
+};
+
+// Rough block interval used to date on-chain transactions, which CLN only
+// reports by block height.
+const BLOCK_INTERVAL_SECS: u64 = 600;
+
+// Invoices read per request while walking back through paid invoices.
+const INVOICE_PAGE_SIZE: u32 = 100;
+
+#[derive(Copy, Clone, Debug, PartialEq, Eq)]
+pub enum TransactionType {
+    Incoming,
+    Outgoing,
+    OnchainDeposit,
+    OnchainWithdrawal,
+}
+
+#[derive(Clone, Debug)]
+pub struct Transaction {
+    pub transaction_type: TransactionType,
+    pub amount_msat: Msat,
+    pub fees_paid_msat: Option<Msat>,
+    // For on-chain deposits this is estimated from the block height.
+    pub timestamp: u64,
+    pub pending: bool,
+    pub payment_hash: Option<String>,
+    pub preimage: Option<String>,
+    pub description: Option<String>,
+    pub bolt11: Option<String>,
+    pub txid: Option<String>,
+}
+
+#[derive(Clone, Debug)]
+pub struct ListAllTransactionsRequest {
+    pub from: Option<u64>,
+    pub limit: Option<u32>,
+}
+
+#[derive(Clone, Debug)]
+pub struct ListAllTransactionsResponse {
+    pub transactions: Vec<Transaction>,
+}
+
+// An outpoint of a wallet transaction, as txid and output index.
+type Outpoint = (String, u32);
+
+// Classifies a wallet transaction by which of its inputs and outputs belong
+// to the wallet. Spending wallet outputs makes it a withdrawal of whatever
+// didn't come back as change; otherwise it is a deposit of the outputs paid
+// to the wallet. Channel opens count as withdrawals. The fee is only known
+// when every input is the wallet's.
+pub(crate) fn classify_onchain(
+    txid: &str,
+    inputs: &[Outpoint],
+    outputs: &[(u32, Msat)],
+    wallet: &HashMap<Outpoint, Msat>,
+) -> Option<(TransactionType, Msat, Option<Msat>)> {
+    let spent: Vec<Msat> = inputs
+        .iter()
+        .filter_map(|input| wallet.get(input).copied())
+        .collect();
+    let total_out: u64 = outputs.iter().map(|(_, amount)| amount.0).sum();
+    let change: u64 = outputs
+        .iter()
+        .filter(|(index, _)| wallet.contains_key(&(txid.to_string(), *index)))
+        .map(|(_, amount)| amount.0)
+        .sum();
+
+    if spent.is_empty() {
+        return (change > 0).then_some((TransactionType::OnchainDeposit, Msat(change), None));
+    }
+    let spent_total: u64 = spent.iter().map(|amount| amount.0).sum();
+    let fee = (spent.len() == inputs.len()).then(|| Msat(spent_total.saturating_sub(total_out)));
+    Some((
+        TransactionType::OnchainWithdrawal,
+        Msat(total_out.saturating_sub(change)),
+        fee,
+    ))
+}
+
+impl GreenlightAlbyClient {
+    // Merges paid invoices, sent payments and wallet transactions into one
+    // feed, newest first. Failed payments and unpaid invoices are left out.
+    // Invoices and payments are read newest first and stop at `from` and
+    // `limit`; CLN can't page wallet transactions, so those are all listed.
+    pub async fn list_all_transactions(
+        &self,
+        req: ListAllTransactionsRequest,
+    ) -> Result<ListAllTransactionsResponse> {
+        let (info, invoices, payments, wallet_txs, funds) = tokio::try_join!(
+            self.get_info(),
+            self.list_paid_invoices(req.from, req.limit),
+            self.list_payments(ListPaymentsRequest {
+                bolt11: None,
+                payment_hash: None,
+                status: None,
+                index: None,
+                start: None,
+                limit: req.limit,
+                created_from: req.from,
+                created_to: None,
+                sort_direction: Some(SortDirection::Descending),
+            }),
+            self.list_wallet_transactions(),
+            self.list_funds(ListFundsRequest { spent: Some(true) }),
+        )?;
+
+        let now = SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .map(|d| d.as_secs())
+            .unwrap_or_default();
+
+        let mut transactions: Vec<Transaction> = Vec::new();
+        transactions.extend(invoices.into_iter().map(|invoice| Transaction {
+            transaction_type: TransactionType::Incoming,
+            amount_msat: invoice.amount_received_msat.unwrap_or_default(),
+            fees_paid_msat: None,
+            timestamp: invoice.paid_at.unwrap_or_default(),
+            pending: false,
+            payment_hash: Some(invoice.payment_hash),
+            preimage: invoice.payment_preimage,
+            description: invoice.description,
+            bolt11: invoice.bolt11,
+            txid: None,
+        }));
+        transactions.extend(
+            payments
+                .payments
+                .into_iter()
+                .filter(|payment| payment.status != ListPaymentsStatus::Failed)
+                .map(|payment| Transaction {
+                    transaction_type: TransactionType::Outgoing,
+                    amount_msat: payment.amount_msat.unwrap_or_default(),
+                    fees_paid_msat: payment
+                        .amount_sent_msat
+                        .zip(payment.amount_msat)
+                        .map(|(sent, amount)| Msat(sent.0.saturating_sub(amount.0))),
+                    timestamp: payment.completed_at.unwrap_or(payment.created_at),
+                    pending: payment.status == ListPaymentsStatus::Pending,
+                    payment_hash: Some(payment.payment_hash),
+                    preimage: payment.preimage,
+                    description: payment.description,
+                    bolt11: payment.bolt11,
+                    txid: None,
+                }),
+        );
+
+        let wallet: HashMap<Outpoint, Msat> = funds
+            .outputs
+            .into_iter()
+            .map(|output| {
+                (
+                    (output.txid, output.output),
+                    output.amount_msat.unwrap_or_default(),
+                )
+            })
+            .collect();
+        transactions.extend(wallet_txs.into_iter().filter_map(|tx| {
+            let txid = hex::encode(&tx.hash);
+            let inputs: Vec<Outpoint> = tx
+                .inputs
+                .iter()
+                .map(|input| (hex::encode(&input.txid), input.index))
+                .collect();
+            let outputs: Vec<(u32, Msat)> = tx
+                .outputs
+                .iter()
+                .map(|output| {
+                    (
+                        output.index,
+                        output
+                            .amount_msat
+                            .clone()
+                            .map(Msat::from)
+                            .unwrap_or_default(),
+                    )
+                })
+                .collect();
+            let (transaction_type, amount_msat, fees_paid_msat) =
+                classify_onchain(&txid, &inputs, &outputs, &wallet)?;
+
+            // Unconfirmed transactions are reported at height 0.
+            let pending = tx.blockheight == 0;
+            let timestamp = if pending {
+                now
+            } else {
+                now.saturating_sub(
+                    u64::from(info.block_height.saturating_sub(tx.blockheight))
+                        * BLOCK_INTERVAL_SECS,
+                )
+            };
+            Some(Transaction {
+                transaction_type,
+                amount_msat,
+                fees_paid_msat,
+                timestamp,
+                pending,
+                payment_hash: None,
+                preimage: None,
+                description: None,
+                bolt11: None,
+                txid: Some(txid),
+            })
+        }));
+
+        if let Some(from) = req.from {
+            transactions.retain(|t| t.timestamp >= from);
+        }
+        transactions.sort_by(|a, b| b.timestamp.cmp(&a.timestamp));
+        if let Some(limit) = req.limit {
+            transactions.truncate(limit as usize);
+        }
+
+        Ok(ListAllTransactionsResponse { transactions })
+    }
+
+    // Paid invoices, newest first. Walks back by updated index: paying an
+    // invoice is the last update it gets, so that is also the order they were
+    // paid in and the walk can stop at `from` or once `limit` were found.
+    async fn list_paid_invoices(
+        &self,
+        from: Option<u64>,
+        limit: Option<u32>,
+    ) -> Result<Vec<ListInvoicesInvoice>> {
+        let limit = limit.map_or(usize::MAX, |limit| limit as usize);
+        let mut end = self
+            .current_index(WaitSubsystem::Invoices, WaitIndexname::Updated)
+            .await?;
+        let mut invoices = Vec::new();
+        // Indexes start at 1.
+        while end > 0 && invoices.len() < limit {
+            let start = end.saturating_sub(u64::from(INVOICE_PAGE_SIZE) - 1).max(1);
+            let mut page = self
+                .list_invoices(ListInvoicesRequest {
+                    label: None,
+                    invstring: None,
+                    payment_hash: None,
+                    offer_id: None,
+                    index: Some(ListInvoicesIndex::Updated),
+                    start: Some(start),
+                    limit: Some((end - start + 1) as u32),
+                    status: None,
+                    exclude_expired: None,
+                })
+                .await?
+                .invoices;
+            page.retain(|i| i.updated_index.map_or(false, |i| i <= end));
+            page.sort_by_key(|i| Reverse(i.updated_index));
+
+            for invoice in page {
+                if invoice.status != ListInvoicesStatus::Paid {
+                    continue;
+                }
+                if from.map_or(false, |from| invoice.paid_at.unwrap_or_default() < from) {
+                    return Ok(invoices);
+                }
+                invoices.push(invoice);
+                if invoices.len() >= limit {
+                    break;
+                }
+            }
+            end = start - 1;
+        }
+        Ok(invoices)
+    }
+
+    async fn list_wallet_transactions(&self) -> Result<Vec<cln::ListtransactionsTransactions>> {
+        Ok(self
+            .node()
+            .list_transactions(cln::ListtransactionsRequest {})
+            .await
+            .context("failed to list wallet transactions")
+            .map_err(SdkError::greenlight_api)?
+            .into_inner()
+            .transactions)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn outpoint(txid: &str, index: u32) -> Outpoint {
+        (txid.to_string(), index)
+    }
+
+    #[test]
+    fn deposit_counts_only_wallet_outputs() {
+        let wallet = HashMap::from([(outpoint("b", 1), Msat(5_000))]);
+        let classified = classify_onchain(
+            "b",
+            &[outpoint("a", 0)],
+            &[(0, Msat(9_000)), (1, Msat(5_000))],
+            &wallet,
+        );
+        assert_eq!(
+            classified,
+            Some((TransactionType::OnchainDeposit, Msat(5_000), None))
+        );
+    }
+
+    #[test]
+    fn withdrawal_excludes_change_and_reports_fee() {
+        let wallet = HashMap::from([
+            (outpoint("a", 0), Msat(10_000)),
+            (outpoint("b", 1), Msat(3_000)),
+        ]);
+        let classified = classify_onchain(
+            "b",
+            &[outpoint("a", 0)],
+            &[(0, Msat(6_000)), (1, Msat(3_000))],
+            &wallet,
+        );
+        assert_eq!(
+            classified,
+            Some((
+                TransactionType::OnchainWithdrawal,
+                Msat(6_000),
+                Some(Msat(1_000))
+            ))
+        );
+    }
+
+    #[test]
+    fn foreign_transaction_is_skipped() {
+        let wallet = HashMap::from([(outpoint("a", 0), Msat(10_000))]);
+        assert_eq!(
+            classify_onchain("b", &[outpoint("c", 0)], &[(0, Msat(1_000))], &wallet),
+            None
+        );
+    }
+}
@@ -0,0 +1,28 @@
+use crate::greenlight_alby_client::{GreenlightAlbyClient, Result};
+
+#[derive(Clone, Debug)]
+pub struct VersionInfo {
+    pub bindings_version: String,
+    pub gl_client_version: String,
+    // Only set when the version was read through a client.
+    pub node_version: Option<String>,
+}
+
+pub fn version() -> VersionInfo {
+    VersionInfo {
+        bindings_version: env!("CARGO_PKG_VERSION").to_string(),
+        gl_client_version: env!("GL_CLIENT_VERSION").to_string(),
+        node_version: None,
+    }
+}
+
+impl GreenlightAlbyClient {
+    // Includes the CLN version reported by the node, e.g. "v23.08gl1".
+    pub async fn version(&self) -> Result<VersionInfo> {
+        let info = self.get_info().await?;
+        Ok(VersionInfo {
+            node_version: Some(info.version),
+            ..version()
+        })
+    }
+}